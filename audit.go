@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/slugalisk/gobf/obfuscator"
+)
+
+// errors
+var (
+	ErrLeaks = errors.New("audit: leaks found")
+)
+
+// audit reports original names left in a built binary or target tree
+func audit(args []string) error {
+	flags := flag.NewFlagSet("audit", flag.ExitOnError)
+	root := flags.String("root", "", "path to the original project root")
+	mappingPath := flags.String("mapping", "", "mapping file written by the build")
+	jsonOutput := flags.Bool("json", false, "write the report as JSON")
+	var secrets stringList
+	flags.Var(&secrets, "secret", "string that must not appear in the build, may be repeated")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 || *mappingPath == "" {
+		return usageError("audit: expected --mapping and the path of one binary or target tree")
+	}
+
+	mapping, err := obfuscator.LoadMapping(*mappingPath)
+	if err != nil {
+		return err
+	}
+	options := obfuscator.AuditOptions{
+		Mapping: mapping,
+		Secrets: secrets,
+	}
+	if *root != "" {
+		options.RootPath, err = filepath.Abs(*root)
+		if err != nil {
+			return err
+		}
+	}
+
+	report, err := obfuscator.Audit(flags.Arg(0), options)
+	if err != nil {
+		return err
+	}
+
+	if *jsonOutput {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\n", data)
+	} else if err := report.WriteText(os.Stdout); err != nil {
+		return err
+	}
+
+	if len(report.Leaks) > 0 {
+		return ErrLeaks
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// configNames are the files a config is looked for under, in order
+var configNames = []string{"gobf.yaml", "gobf.yml", "gobf.toml"}
+
+// config is the obfuscation policy of a project, kept in the repository as
+// gobf.yaml or gobf.toml. Paths are relative to the file and flags given on
+// the command line override it
+type config struct {
+	Src    string `yaml:"src" toml:"src"`
+	Root   string `yaml:"root" toml:"root"`
+	Target string `yaml:"target" toml:"target"`
+
+	Include []string `yaml:"include" toml:"include"`
+	Exclude []string `yaml:"exclude" toml:"exclude"`
+
+	Keep struct {
+		ImportPaths []string `yaml:"importPaths" toml:"importPaths"`
+		FileNames   []string `yaml:"fileNames" toml:"fileNames"`
+		Identifiers []string `yaml:"identifiers" toml:"identifiers"`
+	} `yaml:"keep" toml:"keep"`
+
+	Passes struct {
+		Paths          *bool `yaml:"paths" toml:"paths"`
+		Identifiers    *bool `yaml:"identifiers" toml:"identifiers"`
+		Exported       *bool `yaml:"exported" toml:"exported"`
+		Strings        *bool `yaml:"strings" toml:"strings"`
+		Comments       *bool `yaml:"comments" toml:"comments"`
+		LineDirectives *bool `yaml:"lineDirectives" toml:"lineDirectives"`
+	} `yaml:"passes" toml:"passes"`
+
+	TagReflectedFields *bool    `yaml:"tagReflectedFields" toml:"tagReflectedFields"`
+	InternalTypes      []string `yaml:"internalTypes" toml:"internalTypes"`
+	TagPolicy          string   `yaml:"tagPolicy" toml:"tagPolicy"`
+	TagReport          string   `yaml:"tagReport" toml:"tagReport"`
+	Flatten            []string `yaml:"flatten" toml:"flatten"`
+
+	AliasLength int      `yaml:"aliasLength" toml:"aliasLength"`
+	Seed        string   `yaml:"seed" toml:"seed"`
+	Platforms   []string `yaml:"platforms" toml:"platforms"`
+	Tags        []string `yaml:"tags" toml:"tags"`
+	Tests       *bool    `yaml:"tests" toml:"tests"`
+	Mapping     string   `yaml:"mapping" toml:"mapping"`
+	Cache       string   `yaml:"cache" toml:"cache"`
+}
+
+// findConfig returns the config file named by --config, or the first of
+// configNames found in --root, or --src without it, or the working directory
+// without either
+func findConfig() (string, error) {
+	if *configPath != "" {
+		return *configPath, nil
+	}
+	dir := *rootPath
+	if dir == "" {
+		dir = *srcPath
+	}
+	for _, name := range configNames {
+		filename := filepath.Join(dir, name)
+		if _, err := os.Stat(filename); err == nil {
+			return filename, nil
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+// loadConfig reads a config file
+func loadConfig(filename string) (*config, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &config{}
+	if strings.HasSuffix(filename, ".toml") {
+		var meta toml.MetaData
+		meta, err = toml.Decode(string(data), c)
+		if undecoded := meta.Undecoded(); err == nil && len(undecoded) > 0 {
+			err = fmt.Errorf("unknown key %s", undecoded[0])
+		}
+	} else {
+		err = yaml.UnmarshalStrict(data, c)
+	}
+	if err != nil {
+		return nil, usageError(fmt.Sprintf("%s: %v", filename, err))
+	}
+	return c, nil
+}
+
+// applyConfig fills in the flags that weren't given on the command line from
+// the config, if there is one
+func applyConfig() error {
+	filename, err := findConfig()
+	if err != nil || filename == "" {
+		return err
+	}
+	c, err := loadConfig(filename)
+	if err != nil {
+		return err
+	}
+
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	dir := filepath.Dir(filename)
+	setPath := func(name string, value *string, path string) {
+		if !given[name] && path != "" {
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(dir, path)
+			}
+			*value = path
+		}
+	}
+	setBool := func(name string, value *bool, b *bool) {
+		if !given[name] && b != nil {
+			*value = *b
+		}
+	}
+	setList := func(name string, value *stringList, list []string) {
+		if !given[name] && list != nil {
+			*value = list
+		}
+	}
+
+	setPath("src", srcPath, c.Src)
+	setPath("root", rootPath, c.Root)
+	setPath("target", targetPath, c.Target)
+	setPath("mapping", mappingPath, c.Mapping)
+	setPath("cache", cachePath, c.Cache)
+	setPath("tag-report", tagReportPath, c.TagReport)
+
+	setList("include", &include, c.Include)
+	setList("exclude", &exclude, c.Exclude)
+	setList("keep-import-path", &keepImportPaths, c.Keep.ImportPaths)
+	setList("keep-file-name", &keepFileNames, c.Keep.FileNames)
+	setList("keep-identifier", &keepIdentifiers, c.Keep.Identifiers)
+	setList("internal-type", &internalTypes, c.InternalTypes)
+	setList("flatten", &flatten, c.Flatten)
+	setList("platform", &platforms, c.Platforms)
+	setList("tags", &tags, c.Tags)
+
+	// passes are on when they're true, the flag of the paths pass turns it
+	// off
+	if c.Passes.Paths != nil && !given["keep-paths"] {
+		*keepPaths = !*c.Passes.Paths
+	}
+	setBool("strip-comments", stripComments, c.Passes.Comments)
+	setBool("rename-identifiers", renameIdentifiers, c.Passes.Identifiers)
+	setBool("rename-exported", renameExported, c.Passes.Exported)
+	setBool("encrypt-strings", encryptStrings, c.Passes.Strings)
+	setBool("line-directives", lineDirectives, c.Passes.LineDirectives)
+	setBool("tests", tests, c.Tests)
+	setBool("tag-reflected-fields", tagReflected, c.TagReflectedFields)
+
+	if !given["alias-length"] && c.AliasLength != 0 {
+		*aliasLength = c.AliasLength
+	}
+	if !given["seed"] && c.Seed != "" {
+		*seed = c.Seed
+	}
+	if !given["tag-policy"] && c.TagPolicy != "" {
+		*tagPolicy = c.TagPolicy
+	}
+	return nil
+}
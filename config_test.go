@@ -0,0 +1,139 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/slugalisk/gobf/obfuscator"
+)
+
+const yamlConfig = `src: cmd/server
+target: ../build/obfuscated
+include: [example.com/acme/app/...]
+exclude: [example.com/acme/app/plugins/...]
+passes:
+  paths: false
+  identifiers: true
+  exported: true
+  strings: true
+  lineDirectives: false
+aliasLength: 8
+seed: release
+platforms: [linux/amd64, windows/amd64]
+tags: ["", debug]
+mapping: ../build/obfuscated.mapping.json
+`
+
+const tomlConfig = `src = "cmd/server"
+target = "../build/obfuscated"
+include = ["example.com/acme/app/..."]
+exclude = ["example.com/acme/app/plugins/..."]
+aliasLength = 8
+seed = "release"
+platforms = ["linux/amd64", "windows/amd64"]
+tags = ["", "debug"]
+mapping = "../build/obfuscated.mapping.json"
+
+[passes]
+paths = false
+identifiers = true
+exported = true
+strings = true
+lineDirectives = false
+`
+
+// resetFlags sets the flags of the command back to their defaults once the
+// test is done, the test binary's own flags are left alone
+func resetFlags(t *testing.T) {
+	t.Cleanup(func() {
+		flag.VisitAll(func(f *flag.Flag) {
+			if _, ok := f.Value.(*stringList); !ok && !strings.HasPrefix(f.Name, "test.") {
+				f.Value.Set(f.DefValue)
+			}
+		})
+		platforms, tags, include, exclude = nil, nil, nil, nil
+	})
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	var configs []*config
+	for name, data := range map[string]string{"gobf.yaml": yamlConfig, "gobf.toml": tomlConfig} {
+		filename := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(filename, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		c, err := loadConfig(filename)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		configs = append(configs, c)
+	}
+	if !reflect.DeepEqual(configs[0], configs[1]) {
+		t.Errorf("yaml and toml differ:\n%+v\n%+v", configs[0], configs[1])
+	}
+	if c := configs[0]; c.Passes.Paths == nil || *c.Passes.Paths || c.Passes.LineDirectives == nil || c.Tests != nil {
+		t.Errorf("passes weren't decoded as given: %+v", c.Passes)
+	}
+
+	for name, data := range map[string]string{"bad.yaml": "sedd: typo\n", "bad.toml": "sedd = \"typo\"\n"} {
+		filename := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(filename, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadConfig(filename); err == nil {
+			t.Errorf("%s: unknown key was accepted", name)
+		} else if _, ok := err.(usageError); !ok {
+			t.Errorf("%s: got %T, want a usage error", name, err)
+		}
+	}
+}
+
+func TestParseOptionsConfig(t *testing.T) {
+	for _, name := range []string{"gobf.yaml", "gobf.toml"} {
+		t.Run(name, func(t *testing.T) {
+			resetFlags(t)
+			dir := t.TempDir()
+			data := yamlConfig
+			if name == "gobf.toml" {
+				data = tomlConfig
+			}
+			if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			// flags on the command line win over the config
+			if err := flag.CommandLine.Parse([]string{"--root", dir, "--seed", "cli"}); err != nil {
+				t.Fatal(err)
+			}
+			options, err := parseOptions()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := obfuscator.Options{
+				SrcPath:           filepath.Join(dir, "cmd", "server"),
+				RootPath:          dir,
+				TargetPath:        filepath.Join(filepath.Dir(dir), "build", "obfuscated"),
+				MappingPath:       filepath.Join(filepath.Dir(dir), "build", "obfuscated.mapping.json"),
+				RenameIdentifiers: true,
+				RenameExported:    true,
+				EncryptStrings:    true,
+				KeepPaths:         true,
+				AliasLength:       8,
+				Seed:              "cli",
+				Platforms:         []string{"linux/amd64", "windows/amd64"},
+				Tags:              []string{"", "debug"},
+				Include:           []string{"example.com/acme/app/..."},
+				Exclude:           []string{"example.com/acme/app/plugins/..."},
+			}
+			if !reflect.DeepEqual(options, want) {
+				t.Errorf("got\n%+v\nwant\n%+v", options, want)
+			}
+		})
+	}
+}
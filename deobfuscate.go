@@ -0,0 +1,31 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/slugalisk/gobf/obfuscator"
+)
+
+// deobfuscate rewrites a log or stack trace read from stdin back to original
+// names using a mapping file
+func deobfuscate(args []string) error {
+	flags := flag.NewFlagSet("deobfuscate", flag.ExitOnError)
+	mappingPath := flags.String("mapping", "", "mapping file written by the build that produced the log")
+	flags.Parse(args)
+
+	if *mappingPath == "" {
+		return usageError("deobfuscate: --mapping is required")
+	}
+
+	mapping, err := obfuscator.LoadMapping(*mappingPath)
+	if err != nil {
+		return err
+	}
+	if !mapping.LineDirectives {
+		fmt.Fprintln(os.Stderr, "deobfuscate: the build emitted no line directives, line numbers are those of the rewritten files")
+	}
+
+	return obfuscator.NewDeobfuscator(mapping).Deobfuscate(os.Stdin, os.Stdout)
+}
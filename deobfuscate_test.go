@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/slugalisk/gobf/obfuscator"
+)
+
+// swapFile replaces *f with the named file for the rest of the test
+func swapFile(t *testing.T, f **os.File, name string, flag int) {
+	t.Helper()
+	file, err := os.OpenFile(name, flag, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := *f
+	*f = file
+	t.Cleanup(func() {
+		*f = prev
+		file.Close()
+	})
+}
+
+func TestDeobfuscateWarnsWithoutLineDirectives(t *testing.T) {
+	for _, lines := range []bool{false, true} {
+		dir := t.TempDir()
+		m := obfuscator.NewMapping()
+		m.LineDirectives = lines
+		m.Files["example.com/app/main.go"] = "aBcd.go"
+		mapping := filepath.Join(dir, "mapping.json")
+		if err := m.Save(mapping); err != nil {
+			t.Fatal(err)
+		}
+		in, out, errs := filepath.Join(dir, "in.log"), filepath.Join(dir, "out.log"), filepath.Join(dir, "err.log")
+		if err := ioutil.WriteFile(in, []byte("\t/t/aBcd.go:3\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		swapFile(t, &os.Stdin, in, os.O_RDONLY)
+		swapFile(t, &os.Stdout, out, os.O_CREATE|os.O_WRONLY)
+		swapFile(t, &os.Stderr, errs, os.O_CREATE|os.O_WRONLY)
+
+		if err := deobfuscate([]string{"--mapping", mapping}); err != nil {
+			t.Fatal(err)
+		}
+		if data, _ := ioutil.ReadFile(out); string(data) != "\texample.com/app/main.go:3\n" {
+			t.Errorf("line directives %v: printed %q", lines, data)
+		}
+		data, _ := ioutil.ReadFile(errs)
+		if warned := strings.Contains(string(data), "line directives"); warned == lines {
+			t.Errorf("line directives %v: warned = %v", lines, warned)
+		}
+	}
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"os"
 	"path"
 	"path/filepath"
+	"strings"
 
 	"github.com/slugalisk/gobf/obfuscator"
 )
@@ -13,35 +17,133 @@ var (
 	srcPath    = flag.String("src", "", "path to main package")
 	rootPath   = flag.String("root", "", "path to project root (defaults to --src)")
 	targetPath = flag.String("target", "", "new GOPATH to copy packages to")
+
+	renameIdentifiers = flag.Bool("rename-identifiers", false, "rename unexported identifiers")
+	renameExported    = flag.Bool("rename-exported", false, "also rename exported identifiers of packages under --root")
+	encryptStrings    = flag.Bool("encrypt-strings", false, "encrypt string literals")
+	lineDirectives    = flag.Bool("line-directives", false, "keep original line numbers with //line directives")
+	seed              = flag.String("seed", "", "derive aliases from a seed for reproducible builds")
+	tests             = flag.Bool("tests", false, "rewrite the test files of packages under --root")
+	allErrors         = flag.Bool("all-errors", false, "keep going after a package fails and report every error")
+	cachePath         = flag.String("cache", "", "file to keep package hashes in, only packages that changed since are rewritten")
+	workers           = flag.Int("workers", 0, "number of packages parsed and written concurrently (defaults to the number of CPUs)")
+	dryRun            = flag.Bool("dry-run", false, "print the planned layout of the target without writing it")
+	jsonOutput        = flag.Bool("json", false, "print the --dry-run plan as JSON")
+	configPath        = flag.String("config", "", "config file to read (defaults to gobf.yaml, gobf.yml or gobf.toml in --root)")
+	tagReflected      = flag.Bool("tag-reflected-fields", false, "tag fields only encoding/json and encoding/xml read by name so they can be renamed")
+	keepPaths         = flag.Bool("keep-paths", false, "keep the original import paths and file names of packages")
+	stripComments     = flag.Bool("strip-comments", false, "drop comments from rewritten files but for directives, build constraints and cgo preambles")
+	aliasLength       = flag.Int("alias-length", 0, "length of generated aliases (defaults to 5)")
+	tagPolicy         = flag.String("tag-policy", "keep", "what's done to struct tags of internal types: keep, strip or encrypt")
+	tagReportPath     = flag.String("tag-report", "", "file to write the struct tags changed by --tag-policy to")
+
+	platforms stringList
+	tags      stringList
+	include   stringList
+	exclude   stringList
+
+	keepImportPaths stringList
+	keepFileNames   stringList
+	keepIdentifiers stringList
+	internalTypes   stringList
+	flatten         stringList
+
+	mappingPath         = flag.String("mapping", "", "file to write the alias mapping to (defaults to <target>.mapping.json)")
+	previousMappingPath = flag.String("reuse-mapping", "", "mapping from a previous build whose aliases are reused")
 )
 
-func main() {
-	flag.Parse()
+func init() {
+	flag.Var(&platforms, "platform", "GOOS/GOARCH the target has to build for, may be repeated (defaults to the host)")
+	flag.Var(&tags, "tags", "comma separated build tags the target is built with, may be repeated for several tag sets")
+	flag.Var(&include, "include", "import path pattern of packages to obfuscate, may be repeated (defaults to every package)")
+	flag.Var(&exclude, "exclude", "import path pattern of packages to leave unobfuscated, may be repeated")
+	flag.Var(&keepImportPaths, "keep-import-path", "import path pattern of packages that keep their paths, may be repeated")
+	flag.Var(&keepFileNames, "keep-file-name", "pattern of go files, as import path/name.go, that keep their names, may be repeated")
+	flag.Var(&keepIdentifiers, "keep-identifier", "pattern of identifiers, as import path.Name, that keep their names, may be repeated")
+	flag.Var(&flatten, "flatten", "pattern of functions, as import path.Name or import path.Type.Method, to flatten the control flow of, may be repeated")
+	flag.Var(&internalTypes, "internal-type", "pattern of types, as import path.Name, whose values never leave the process, may be repeated")
+}
 
-	options := obfuscator.Options{}
+// stringList is a flag that may be given several times
+type stringList []string
 
-	var err error
-	options.SrcPath, err = filepath.Abs(*srcPath)
-	if err != nil {
-		panic(err)
+func (l *stringList) String() string {
+	return strings.Join(*l, " ")
+}
+
+// Set implements flag.Value
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// commands run instead of rewriting when named by the first argument
+var commands = map[string]func(args []string) error{
+	"audit":       audit,
+	"deobfuscate": deobfuscate,
+	"scrub":       scrub,
+	"verify":      verify,
+}
+
+// exit codes, rewrite failures exit with the code of their kind
+const (
+	exitError = 1
+	exitUsage = 2
+	exitLeaks = 3
+)
+
+var exitCodes = map[obfuscator.ErrorKind]int{
+	obfuscator.OptionsError: exitUsage,
+	obfuscator.ResolveError: 4,
+	obfuscator.ParseError:   5,
+	obfuscator.CheckError:   6,
+	obfuscator.WriteError:   7,
+}
+
+// usageError is a command line that can't be run
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				exit(err)
+			}
+			return
+		}
 	}
-	options.TargetPath, err = filepath.Abs(*targetPath)
+
+	flag.Parse()
+
+	options, err := parseOptions()
 	if err != nil {
-		panic(err)
+		exit(err)
 	}
 
-	if rootPath == nil {
-		options.RootPath = *srcPath
-	} else {
-		options.RootPath, err = filepath.Abs(*rootPath)
-		if err != nil {
-			panic(err)
+	if options.DryRun {
+		if err := printPlan(options); err != nil {
+			exit(err)
 		}
+		return
 	}
 
 	alias, err := obfuscator.Rewrite(options)
 	if err != nil {
-		panic(err)
+		exit(err)
+	}
+
+	if obfuscator.UsesModules(options) {
+		fmt.Printf(
+			"ready to build:\ncd %s && GO111MODULE=on go build -o %s %s\n",
+			options.TargetPath,
+			path.Base(options.SrcPath),
+			alias,
+		)
+		return
 	}
 
 	fmt.Printf(
@@ -51,3 +153,133 @@ func main() {
 		alias,
 	)
 }
+
+// printPlan prints what a rewrite would write to the target
+func printPlan(options obfuscator.Options) error {
+	plan, err := obfuscator.PlanRewrite(options)
+	if err != nil {
+		return err
+	}
+	if !*jsonOutput {
+		return plan.WriteText(os.Stdout)
+	}
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n", data)
+	return nil
+}
+
+// exit prints err and exits with a code scripts can tell apart. collected
+// errors are printed in turn and exit with the code of the first
+func exit(err error) {
+	errs, ok := err.(obfuscator.Errors)
+	if !ok || len(errs) == 0 {
+		os.Exit(printError(err))
+	}
+	code := printError(errs[0])
+	for _, err := range errs[1:] {
+		printError(err)
+	}
+	fmt.Fprintf(os.Stderr, "%d errors\n", len(errs))
+	os.Exit(code)
+}
+
+// printError prints err readably and returns its exit code
+func printError(err error) int {
+	code := exitError
+	var rerr *obfuscator.Error
+	switch {
+	case errors.As(err, &rerr):
+		if rerr.Path == "" {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", rerr.Kind, rerr.Err)
+		} else {
+			fmt.Fprintf(os.Stderr, "%s %s: %v\n", rerr.Kind, rerr.Path, rerr.Err)
+		}
+		if len(rerr.ImportChain) > 0 {
+			fmt.Fprintf(os.Stderr, "\timport chain: %s\n", strings.Join(rerr.ImportChain, " -> "))
+		}
+		code = exitCodes[rerr.Kind]
+	case errors.As(err, new(usageError)):
+		fmt.Fprintln(os.Stderr, err)
+		code = exitUsage
+	case err == ErrLeaks:
+		fmt.Fprintln(os.Stderr, err)
+		code = exitLeaks
+	default:
+		fmt.Fprintln(os.Stderr, err)
+	}
+	return code
+}
+
+// parseOptions builds rewrite options from the parsed command line flags
+// and the config file
+func parseOptions() (obfuscator.Options, error) {
+	if err := applyConfig(); err != nil {
+		return obfuscator.Options{}, err
+	}
+
+	options := obfuscator.Options{
+		RenameIdentifiers:  *renameIdentifiers,
+		RenameExported:     *renameExported,
+		EncryptStrings:     *encryptStrings,
+		LineDirectives:     *lineDirectives,
+		Seed:               *seed,
+		Tests:              *tests,
+		TagReflectedFields: *tagReflected,
+		KeepPaths:          *keepPaths,
+		StripComments:      *stripComments,
+		Include:            include,
+		Exclude:            exclude,
+		KeepImportPaths:    keepImportPaths,
+		KeepFileNames:      keepFileNames,
+		KeepIdentifiers:    keepIdentifiers,
+		InternalTypes:      internalTypes,
+		Flatten:            flatten,
+		TagReportPath:      *tagReportPath,
+		AliasLength:        *aliasLength,
+		CollectErrors:      *allErrors,
+		DryRun:             *dryRun,
+		Workers:            *workers,
+		CachePath:          *cachePath,
+		Platforms:          platforms,
+		Tags:               tags,
+	}
+
+	if *srcPath == "" || *targetPath == "" {
+		return options, usageError("--src and --target are required")
+	}
+
+	var err error
+	options.TagPolicy, err = obfuscator.ParseTagPolicy(*tagPolicy)
+	if err != nil {
+		return options, usageError(err.Error())
+	}
+
+	options.SrcPath, err = filepath.Abs(*srcPath)
+	if err != nil {
+		return options, err
+	}
+	options.TargetPath, err = filepath.Abs(*targetPath)
+	if err != nil {
+		return options, err
+	}
+
+	options.MappingPath = *mappingPath
+	if options.MappingPath == "" {
+		options.MappingPath = options.TargetPath + ".mapping.json"
+	}
+	options.PreviousMappingPath = *previousMappingPath
+
+	if *rootPath == "" {
+		options.RootPath = options.SrcPath
+	} else {
+		options.RootPath, err = filepath.Abs(*rootPath)
+		if err != nil {
+			return options, err
+		}
+	}
+
+	return options, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/slugalisk/gobf/obfuscator"
+)
+
+var exitErrors = map[string]error{
+	"other":   errors.New("failed"),
+	"usage":   usageError("--src and --target are required"),
+	"leaks":   ErrLeaks,
+	"options": &obfuscator.Error{Kind: obfuscator.OptionsError, Err: obfuscator.ErrPlatform},
+	"resolve": &obfuscator.Error{Kind: obfuscator.ResolveError, Err: errors.New("not found")},
+	"parse":   &obfuscator.Error{Kind: obfuscator.ParseError, Err: errors.New("expected )")},
+	"check":   fmt.Errorf("rewrite: %w", &obfuscator.Error{Kind: obfuscator.CheckError, Err: errors.New("undefined")}),
+	"write":   &obfuscator.Error{Kind: obfuscator.WriteError, Err: errors.New("read-only")},
+}
+
+func TestExitCodes(t *testing.T) {
+	// the test binary runs itself to observe the exit status
+	if name := os.Getenv("GOBF_EXIT_ERROR"); name != "" {
+		exit(exitErrors[name])
+		return
+	}
+
+	want := map[string]int{
+		"other":   1,
+		"usage":   2,
+		"leaks":   3,
+		"options": 2,
+		"resolve": 4,
+		"parse":   5,
+		"check":   6,
+		"write":   7,
+	}
+	for name, code := range want {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestExitCodes$")
+		cmd.Env = append(os.Environ(), "GOBF_EXIT_ERROR="+name)
+		err := cmd.Run()
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			t.Errorf("%s: got %v, want exit status %d", name, err, code)
+			continue
+		}
+		if exitErr.ExitCode() != code {
+			t.Errorf("%s: exit status %d, want %d", name, exitErr.ExitCode(), code)
+		}
+	}
+}
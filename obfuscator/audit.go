@@ -0,0 +1,387 @@
+package obfuscator
+
+import (
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// kinds of leaks
+const (
+	LeakModule     = "module"
+	LeakImportPath = "import path"
+	LeakDirectory  = "directory"
+	LeakFile       = "file"
+	LeakIdentifier = "identifier"
+	LeakUser       = "user"
+	LeakSecret     = "secret"
+)
+
+// homeDirectory matches $HOME style paths, the user name is the second group.
+// Matches are only reported where a path starts
+var homeDirectory = regexp.MustCompile(`(/home/|/Users/|[A-Za-z]:\\Users\\)([A-Za-z0-9._-]+)|/root/`)
+
+// AuditOptions ...
+type AuditOptions struct {
+	// RootPath is the original project root
+	RootPath string
+	// Mapping is the mapping written by the build being audited
+	Mapping *Mapping
+	// Secrets are strings that must not appear anywhere
+	Secrets []string
+}
+
+// Leak is an original name found in the audited build. Text files report
+// the line it's on, binaries the byte offset
+type Leak struct {
+	Kind   string `json:"kind"`
+	Value  string `json:"value"`
+	File   string `json:"file"`
+	Line   int    `json:"line,omitempty"`
+	Offset int64  `json:"offset"`
+}
+
+func (l Leak) String() string {
+	if l.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s %q", l.File, l.Line, l.Kind, l.Value)
+	}
+	return fmt.Sprintf("%s+%#x: %s %q", l.File, l.Offset, l.Kind, l.Value)
+}
+
+// AuditReport lists every leak found
+type AuditReport struct {
+	Leaks []Leak `json:"leaks"`
+}
+
+// Audit scans a built binary or a target tree for anything that identifies
+// the original project: module and import paths, directories, file names,
+// renamed identifiers, user names in home directory paths and secrets
+func Audit(filename string, options AuditOptions) (*AuditReport, error) {
+	a := &auditor{
+		options: options,
+		report:  &AuditReport{Leaks: []Leak{}},
+	}
+	a.collectNeedles()
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		err = filepath.Walk(filename, func(name string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(filename, name)
+			if err != nil {
+				return err
+			}
+			return a.auditFile(name, filepath.ToSlash(rel), true)
+		})
+	} else {
+		err = a.auditFile(filename, filepath.Base(filename), false)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(a.report.Leaks, func(i, j int) bool {
+		li, lj := a.report.Leaks[i], a.report.Leaks[j]
+		if li.File != lj.File {
+			return li.File < lj.File
+		}
+		return li.Offset < lj.Offset
+	})
+	return a.report, nil
+}
+
+type auditNeedle struct {
+	kind  string
+	value string
+}
+
+type auditor struct {
+	options AuditOptions
+	report  *AuditReport
+	needles []auditNeedle
+
+	// renamed identifiers by original import path of the package declaring
+	// them, shared fields and methods are under ""
+	identifiers map[string]map[string]struct{}
+	// original import paths by the last element of their alias
+	packages map[string]string
+}
+
+// collectNeedles lists the strings searched for in every file
+func (a *auditor) collectNeedles() {
+	m := a.options.Mapping
+	add := func(kind, value string) {
+		if value != "" {
+			a.needles = append(a.needles, auditNeedle{kind, value})
+		}
+	}
+
+	add(LeakDirectory, a.options.RootPath)
+	for key := range m.Modules {
+		if i := strings.LastIndex(key, "@"); i != -1 {
+			key = key[:i]
+		}
+		add(LeakModule, key)
+	}
+	a.packages = make(map[string]string)
+	for name, importPath := range m.ImportPaths {
+		add(LeakImportPath, name)
+		a.packages[path.Base(importPath)] = name
+	}
+	for dir := range m.Directories {
+		add(LeakDirectory, dir)
+	}
+	// file names on their own are too common, they're searched for along
+	// with the name of their directory
+	for key := range m.Files {
+		if strings.Contains(key, ":") {
+			continue
+		}
+		add(LeakFile, path.Join(path.Base(path.Dir(key)), path.Base(key)))
+	}
+	for _, secret := range a.options.Secrets {
+		add(LeakSecret, secret)
+	}
+
+	// binaries qualify package level names with the package's import path
+	a.identifiers = make(map[string]map[string]struct{})
+	for key := range m.Identifiers {
+		pkgPath, kind, name := splitIdentifierKey(key)
+		if kind != "" && kind != "field" && kind != "method" {
+			continue
+		}
+		if a.identifiers[pkgPath] == nil {
+			a.identifiers[pkgPath] = make(map[string]struct{})
+		}
+		a.identifiers[pkgPath][name] = struct{}{}
+		if kind == "" {
+			if importPath, ok := m.ImportPaths[pkgPath]; ok {
+				add(LeakIdentifier, importPath+"."+name)
+			}
+		}
+	}
+
+	// longer needles first so a leak is reported by its most specific name
+	sort.SliceStable(a.needles, func(i, j int) bool {
+		return len(a.needles[i].value) > len(a.needles[j].value)
+	})
+}
+
+// splitIdentifierKey splits a namer key into the import path of the package
+// declaring the identifier, its kind and its name
+func splitIdentifierKey(key string) (string, string, string) {
+	slash := strings.LastIndex(key, "/")
+	pkgPath, rest := "", key
+	if dot := strings.Index(key[slash+1:], "."); dot != -1 {
+		pkgPath, rest = key[:slash+1+dot], key[slash+1+dot+1:]
+	}
+	if i := strings.Index(rest, ":"); i != -1 {
+		return pkgPath, rest[:i], rest[i+1:]
+	}
+	return pkgPath, "", rest
+}
+
+// auditFile scans one file, compressed ELF sections are scanned
+// uncompressed and go files in a target tree are parsed for identifiers
+func (a *auditor) auditFile(filename, name string, tree bool) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	a.scan(name, data)
+
+	if f, err := elf.NewFile(bytes.NewReader(data)); err == nil {
+		for _, section := range f.Sections {
+			if section.Flags&elf.SHF_COMPRESSED == 0 || section.Type == elf.SHT_NOBITS {
+				continue
+			}
+			plain, err := section.Data()
+			if err != nil {
+				return err
+			}
+			a.scan(fmt.Sprintf("%s[%s]", name, section.Name), plain)
+		}
+		f.Close()
+	} else if tree && strings.HasSuffix(name, ".go") {
+		a.auditGoFile(name, data)
+	}
+	return nil
+}
+
+// scan searches data for every needle and home directory path. Needles
+// are only reported where they make up whole path elements, see pathBounded
+func (a *auditor) scan(name string, data []byte) {
+	text := bytes.IndexByte(data, 0) == -1
+	report := func(kind, value string, offset int) {
+		leak := Leak{Kind: kind, Value: value, File: name, Offset: int64(offset)}
+		if text {
+			leak.Line = bytes.Count(data[:offset], []byte("\n")) + 1
+		}
+		a.report.Leaks = append(a.report.Leaks, leak)
+	}
+
+	// a needle found inside a longer one that was already reported isn't
+	// reported again
+	var found [][2]int
+	covered := func(start, end int) bool {
+		for _, f := range found {
+			if f[0] <= start && end <= f[1] {
+				return true
+			}
+		}
+		return false
+	}
+	for _, needle := range a.needles {
+		value := []byte(needle.value)
+		for offset := 0; ; {
+			i := bytes.Index(data[offset:], value)
+			if i == -1 {
+				break
+			}
+			start, end := offset+i, offset+i+len(value)
+			if !pathBounded(data, start, len(value)) {
+				offset = start + 1
+				continue
+			}
+			if !covered(start, end) {
+				report(needle.kind, needle.value, start)
+				found = append(found, [2]int{start, end})
+			}
+			offset = end
+		}
+	}
+
+	for _, match := range homeDirectory.FindAllSubmatchIndex(data, -1) {
+		if match[0] > 0 && isPathByte(data[match[0]-1]) {
+			continue
+		}
+		user := "root"
+		if match[4] != -1 {
+			user = string(data[match[4]:match[5]])
+		}
+		report(LeakUser, user, match[0])
+	}
+}
+
+// auditGoFile reports renamed identifiers that appear in rewritten source
+// under their original name. Only package level declarations, fields,
+// methods and selectors are considered, locals and parameters keep their
+// names by design
+func (a *auditor) auditGoFile(name string, data []byte) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, name, data, 0)
+	if err != nil {
+		return
+	}
+
+	// a package is in a directory named after its alias whether the tree
+	// is a GOPATH or a module, exported fields and methods are shared by
+	// every package
+	known := func(ident string) bool {
+		pkgPath, ok := a.packages[path.Base(path.Dir(name))]
+		if !ok {
+			for _, names := range a.identifiers {
+				if _, ok := names[ident]; ok {
+					return true
+				}
+			}
+			return false
+		}
+		if _, ok := a.identifiers[pkgPath][ident]; ok {
+			return true
+		}
+		_, ok = a.identifiers[""][ident]
+		return ok
+	}
+
+	imports := make(map[string]struct{})
+	for _, imp := range file.Imports {
+		if imp.Name != nil {
+			imports[imp.Name.Name] = struct{}{}
+		} else {
+			imports[path.Base(strings.Trim(imp.Path.Value, `"`))] = struct{}{}
+		}
+	}
+
+	check := func(ident *ast.Ident) {
+		if ident != nil && known(ident.Name) {
+			position := fset.Position(ident.Pos())
+			a.report.Leaks = append(a.report.Leaks, Leak{
+				Kind:   LeakIdentifier,
+				Value:  ident.Name,
+				File:   name,
+				Line:   position.Line,
+				Offset: int64(position.Offset),
+			})
+		}
+	}
+
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			check(decl.Name)
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					check(spec.Name)
+				case *ast.ValueSpec:
+					for _, ident := range spec.Names {
+						check(ident)
+					}
+				}
+			}
+		}
+	}
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.StructType:
+			for _, field := range n.Fields.List {
+				for _, ident := range field.Names {
+					check(ident)
+				}
+			}
+		case *ast.InterfaceType:
+			for _, method := range n.Methods.List {
+				for _, ident := range method.Names {
+					check(ident)
+				}
+			}
+		case *ast.SelectorExpr:
+			if x, ok := n.X.(*ast.Ident); ok {
+				if _, ok := imports[x.Name]; ok {
+					return true
+				}
+			}
+			check(n.Sel)
+		}
+		return true
+	})
+}
+
+// WriteText writes one leak per line
+func (r *AuditReport) WriteText(w io.Writer) error {
+	for _, leak := range r.Leaks {
+		if _, err := fmt.Fprintln(w, leak); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%d leaks found\n", len(r.Leaks))
+	return err
+}
@@ -0,0 +1,47 @@
+package obfuscator
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAuditScan(t *testing.T) {
+	tests := []struct {
+		data  string
+		leaks []string
+	}{
+		{"/tmp/s/main.go", []string{"directory /tmp/s"}},
+		{"/tmp/so/main.go", nil},
+		{"/tmp/s", []string{"directory /tmp/s"}},
+		{"example.com/app.Handler", []string{"module example.com/app"}},
+		{"example.com/apps", nil},
+		{"\x00example.com/app/users\x00", []string{"import path example.com/app/users"}},
+		{"subexample.com/app", nil},
+		{"/home/alice/src", []string{"user alice"}},
+		{"/var/home/alice/src", nil},
+		{"/root/go", []string{"user root"}},
+		{"/usr/root/go", nil},
+		{`"C:\Users\bob\go"`, []string{"user bob"}},
+		{"secret-token", []string{"secret secret-token"}},
+		{"mysecret-tokens", nil},
+	}
+	for _, test := range tests {
+		a := &auditor{
+			report: &AuditReport{},
+			needles: []auditNeedle{
+				{LeakImportPath, "example.com/app/users"},
+				{LeakModule, "example.com/app"},
+				{LeakSecret, "secret-token"},
+				{LeakDirectory, "/tmp/s"},
+			},
+		}
+		a.scan("file", []byte(test.data))
+		var leaks []string
+		for _, leak := range a.report.Leaks {
+			leaks = append(leaks, leak.Kind+" "+leak.Value)
+		}
+		if !reflect.DeepEqual(leaks, test.leaks) {
+			t.Errorf("scan(%q) = %q, want %q", test.data, leaks, test.leaks)
+		}
+	}
+}
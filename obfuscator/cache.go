@@ -0,0 +1,208 @@
+package obfuscator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// errors
+var (
+	ErrCacheVersion = errors.New("unsupported cache file version")
+)
+
+const (
+	// CacheVersion is the version of the cache file format, and of the hash
+	// stored in it
+	CacheVersion = 1
+)
+
+// Cache records what an incremental rewrite last wrote to its target. Target
+// directories are keyed by their aliases so the file holds no original names
+type Cache struct {
+	Version   int               `json:"version"`
+	StringKey []byte            `json:"stringKey,omitempty"`
+	Packages  map[string]string `json:"packages"`
+}
+
+// loadCache reads the cache file, or returns an empty cache if there's none
+func loadCache(filename string) (*Cache, error) {
+	c := &Cache{
+		Version:  CacheVersion,
+		Packages: make(map[string]string),
+	}
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return c, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	if c.Version != CacheVersion {
+		return nil, ErrCacheVersion
+	}
+	if c.Packages == nil {
+		c.Packages = make(map[string]string)
+	}
+	return c, nil
+}
+
+// save writes the cache as indented JSON
+func (c *Cache) save(filename string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, append(data, '\n'), 0644)
+}
+
+// jobHash hashes everything the output of a job depends on: the options, the
+// files of the package as they're read and copied, the aliases its renamed
+// identifiers and imports were given and the files generated for it. A
+// package whose hash didn't change is written exactly as it was last time
+func (r *rewriter) jobHash(job *packageJob) (string, error) {
+	h := sha256.New()
+	o := r.options
+	fmt.Fprintf(h, "options %d %t %t %t %t %t %t %t %q %q %d\n", CacheVersion,
+		o.RenameIdentifiers, o.RenameExported, o.EncryptStrings, o.LineDirectives,
+		o.Tests, o.KeepPaths, o.StripComments, o.Platforms, o.Tags, aliasLength(o))
+	fmt.Fprintf(h, "reflection %t %q %s\n", o.TagReflectedFields, o.InternalTypes, o.TagPolicy)
+	fmt.Fprintf(h, "policy %q %q %q %q %q %q\n", o.Include, o.Exclude, o.KeepImportPaths,
+		o.KeepFileNames, o.KeepIdentifiers, o.Flatten)
+	fmt.Fprintf(h, "package %s %t\n", job.dir, job.tests)
+
+	if err := r.hashSources(h, job); err != nil {
+		return "", err
+	}
+
+	var keys []string
+	for key := range job.aliases {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(h, "alias %s %s\n", key, job.aliases[key])
+	}
+
+	for _, f := range job.files {
+		fmt.Fprintf(h, "go %s %s %q\n", f.src, f.alias, f.constraints)
+		for _, imp := range f.file.Imports {
+			fmt.Fprintf(h, "import %s\n", imp.Path.Value)
+		}
+	}
+	for _, g := range job.generated {
+		fmt.Fprintf(h, "generated %s %d\n", g.name, len(g.data))
+		h.Write(g.data)
+	}
+	for _, tag := range job.tags {
+		fmt.Fprintf(h, "tag %s\n", tag)
+	}
+	for _, names := range [][]string{job.pkg.IgnoredGoFiles, job.pkg.IgnoredOtherFiles} {
+		fmt.Fprintf(h, "ignored %q\n", names)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashSources hashes the files the job reads or copies: those directly in
+// the package directory and the paths under it the package uses. packages
+// in subdirectories are jobs of their own and aren't hashed
+func (r *rewriter) hashSources(h hash.Hash, job *packageJob) error {
+	dir := job.pkg.Dir
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if info.Mode().IsRegular() {
+			if err := hashFile(h, dir, filepath.Join(dir, info.Name()), info); err != nil {
+				return err
+			}
+		}
+	}
+	for _, rel := range r.usedPaths(job) {
+		if err := hashPath(h, dir, filepath.Join(dir, rel)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hashPath hashes a file, or the files under a directory the way copyPath
+// copies them. paths that don't exist are skipped
+func hashPath(h hash.Hash, dir, src string) error {
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		return nil
+	}
+	return filepath.Walk(src, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if name != src && skipCopyDir(name) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() || skipCopy(info.Name()) {
+			return nil
+		}
+		return hashFile(h, dir, name, info)
+	})
+}
+
+// hashFile hashes the name relative to dir, mode and contents of a file
+func hashFile(h hash.Hash, dir, name string, info os.FileInfo) error {
+	rel, err := filepath.Rel(dir, name)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(h, "file %s %v %d\n", filepath.ToSlash(rel), info.Mode(), info.Size())
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(h, f)
+	return err
+}
+
+// removeStalePackages deletes the target directories of cached packages the
+// rewrite no longer reaches, they'd otherwise linger in the target. only the
+// files are removed from a directory that holds packages still written
+func (r *rewriter) removeStalePackages() error {
+	current := make(map[string]struct{}, len(r.jobs))
+	for _, job := range r.jobs {
+		current[job.dir] = struct{}{}
+	}
+	for dir := range r.cache.Packages {
+		if _, ok := current[dir]; ok {
+			continue
+		}
+		delete(r.cache.Packages, dir)
+		if !strings.HasPrefix(dir, r.options.TargetPath+string(filepath.Separator)) {
+			continue
+		}
+		if r.holdsPackage(dir) {
+			if err := removeDirFiles(dir); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
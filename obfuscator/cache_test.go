@@ -0,0 +1,227 @@
+package obfuscator
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const cacheMain = `package main
+
+import (
+	"fmt"
+
+	"example.com/cache/alpha"
+	"example.com/cache/beta"
+)
+
+func main() { fmt.Println(alpha.Name(), beta.Name()) }
+`
+
+// markTarget appends a marker to the target go files containing text and
+// returns their names. a file rewritten since loses its marker
+func markTarget(t *testing.T, target, text string) []string {
+	t.Helper()
+	var names []string
+	for _, name := range targetFiles(t, target) {
+		filename := filepath.Join(target, filepath.FromSlash(name))
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasSuffix(name, ".go") && strings.Contains(string(data), text) {
+			if err := ioutil.WriteFile(filename, append(data, "\n// marked\n"...), 0644); err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, filename)
+		}
+	}
+	if len(names) == 0 {
+		t.Fatalf("no target file contains %q", text)
+	}
+	return names
+}
+
+func marked(t *testing.T, filename string) bool {
+	t.Helper()
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return false
+	}
+	return strings.HasSuffix(string(data), "// marked\n")
+}
+
+func TestRewriteCache(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"go.mod":          "module example.com/cache\n\ngo 1.16\n",
+		"cmd/app/main.go": cacheMain,
+		"alpha/alpha.go":  "package alpha\n\nfunc Name() string { return label() }\n\nfunc label() string { return \"alpha-one\" }\n",
+		"beta/beta.go":    "package beta\n\nfunc Name() string { return label() }\n\nfunc label() string { return \"beta-one\" }\n",
+	})
+	state := t.TempDir()
+	options := Options{
+		SrcPath:           filepath.Join(root, "cmd", "app"),
+		RootPath:          root,
+		TargetPath:        t.TempDir(),
+		RenameIdentifiers: true,
+		MappingPath:       filepath.Join(state, "mapping.json"),
+		CachePath:         filepath.Join(state, "cache.json"),
+	}
+	if out := runTarget(t, options); out != "alpha-one beta-one\n" {
+		t.Fatalf("target printed %q", out)
+	}
+
+	cache, err := loadCache(options.CachePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cache.Packages) != 3 {
+		t.Errorf("cache has %d packages, want 3", len(cache.Packages))
+	}
+	data, err := ioutil.ReadFile(options.CachePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "example.com/cache") || strings.Contains(string(data), root) {
+		t.Error("cache file holds original names")
+	}
+
+	// only the edited package misses the cache
+	alpha := markTarget(t, options.TargetPath, "alpha-one")
+	beta := markTarget(t, options.TargetPath, "beta-one")
+	writeTree(t, root, map[string]string{
+		"beta/beta.go": "package beta\n\nfunc Name() string { return label() }\n\nfunc label() string { return \"beta-two\" }\n",
+	})
+	if out := runTarget(t, options); out != "alpha-one beta-two\n" {
+		t.Fatalf("target printed %q after an edit", out)
+	}
+	for _, filename := range alpha {
+		if !marked(t, filename) {
+			t.Errorf("unchanged %s was written again", filename)
+		}
+	}
+	for _, filename := range beta {
+		if marked(t, filename) {
+			t.Errorf("edited %s wasn't written again", filename)
+		}
+	}
+
+	// files the package neither reads nor copies don't invalidate it, nor do
+	// packages nested under it
+	writeTree(t, root, map[string]string{
+		"alpha/notes/todo.txt":     "later",
+		"alpha/inner/inner.go":     "package inner\n",
+		"alpha/vendor/modules.txt": "",
+		"alpha/.git/HEAD":          "ref: refs/heads/master\n",
+	})
+	if out := runTarget(t, options); out != "alpha-one beta-two\n" {
+		t.Fatalf("target printed %q after adding nested files", out)
+	}
+	for _, filename := range alpha {
+		if !marked(t, filename) {
+			t.Errorf("%s was written again for files it doesn't use", filename)
+		}
+	}
+	writeTree(t, root, map[string]string{
+		"alpha/alpha.txt": "copied along",
+	})
+	if out := runTarget(t, options); out != "alpha-one beta-two\n" {
+		t.Fatalf("target printed %q after adding a file", out)
+	}
+	for _, filename := range alpha {
+		if marked(t, filename) {
+			t.Errorf("%s wasn't written again for a file it copies", filename)
+		}
+	}
+
+	// packages no longer imported are removed from the target and the cache
+	writeTree(t, root, map[string]string{
+		"cmd/app/main.go": "package main\n\nimport (\n\t\"fmt\"\n\n\t\"example.com/cache/alpha\"\n)\n\nfunc main() { fmt.Println(alpha.Name()) }\n",
+	})
+	if out := runTarget(t, options); out != "alpha-one\n" {
+		t.Fatalf("target printed %q without beta", out)
+	}
+	for _, name := range targetFiles(t, options.TargetPath) {
+		data, err := ioutil.ReadFile(filepath.Join(options.TargetPath, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), "beta-two") {
+			t.Errorf("stale %s is still in the target", name)
+		}
+	}
+	cache, err = loadCache(options.CachePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cache.Packages) != 2 {
+		t.Errorf("cache has %d packages, want 2", len(cache.Packages))
+	}
+}
+
+func TestLoadCache(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := loadCache(filepath.Join(dir, "missing.json"))
+	if err != nil || len(cache.Packages) != 0 || cache.Version != CacheVersion {
+		t.Errorf("missing cache file: got %v, %v", cache, err)
+	}
+
+	filename := filepath.Join(dir, "old.json")
+	if err := ioutil.WriteFile(filename, []byte(`{"version": 0, "packages": {}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadCache(filename); err != ErrCacheVersion {
+		t.Errorf("got %v, want %v", err, ErrCacheVersion)
+	}
+}
+
+func TestRemoveStalePackages(t *testing.T) {
+	target := t.TempDir()
+	outside := t.TempDir()
+	current := filepath.Join(target, "current")
+	stale := filepath.Join(target, "stale")
+	// a stale package whose directory holds a current one
+	parent := filepath.Join(target, "parent")
+	child := filepath.Join(parent, "child")
+	writeTree(t, target, map[string]string{
+		"current/a.go":      "package a\n",
+		"stale/b.go":        "package b\n",
+		"parent/p.go":       "package p\n",
+		"parent/child/c.go": "package c\n",
+	})
+
+	r := &rewriter{
+		options: Options{TargetPath: target},
+		cache: &Cache{Packages: map[string]string{
+			current: "a",
+			stale:   "b",
+			outside: "c",
+			parent:  "d",
+		}},
+		jobs: []*packageJob{{dir: current}, {dir: child}},
+	}
+	if err := r.removeStalePackages(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(current); err != nil {
+		t.Errorf("current package was removed: %v", err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("stale package is still there: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(parent, "p.go")); !os.IsNotExist(err) {
+		t.Errorf("stale package file is still there: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(child, "c.go")); err != nil {
+		t.Errorf("package under a stale one was removed: %v", err)
+	}
+	if _, err := os.Stat(outside); err != nil {
+		t.Errorf("directory outside the target was removed: %v", err)
+	}
+	if len(r.cache.Packages) != 1 {
+		t.Errorf("cache still has %v", r.cache.Packages)
+	}
+}
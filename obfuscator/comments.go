@@ -0,0 +1,109 @@
+package obfuscator
+
+import (
+	"go/ast"
+	"go/build/constraint"
+	"strings"
+)
+
+// directivePrefixes start the comments the toolchain reads: compiler
+// directives, line directives in both comment forms and cgo exports
+var directivePrefixes = []string{"//go:", "//line ", "/*line ", "//export "}
+
+// stripComments drops every comment of a file but the cgo preamble of its
+// import "C" declaration and directives. Build constraints are written ahead
+// of the file by the rewrite so they're dropped too
+func stripComments(file *ast.File) {
+	preambles := make(map[*ast.CommentGroup]struct{})
+	for _, decl := range file.Decls {
+		decl, ok := decl.(*ast.GenDecl)
+		if !ok {
+			continue
+		}
+		// the preamble is the doc of the import spec, or of the declaration
+		// when it's the only spec
+		for _, spec := range decl.Specs {
+			if imp, ok := spec.(*ast.ImportSpec); ok && imp.Path.Value == `"C"` {
+				if imp.Doc != nil {
+					preambles[imp.Doc] = struct{}{}
+				}
+				if len(decl.Specs) == 1 && decl.Doc != nil {
+					preambles[decl.Doc] = struct{}{}
+				}
+			}
+		}
+	}
+
+	var comments []*ast.CommentGroup
+	for _, group := range file.Comments {
+		if _, ok := preambles[group]; ok {
+			comments = append(comments, group)
+			continue
+		}
+		var list []*ast.Comment
+		for _, c := range group.List {
+			if isDirective(c.Text) {
+				list = append(list, c)
+			}
+		}
+		if len(list) > 0 {
+			comments = append(comments, &ast.CommentGroup{List: list})
+		}
+	}
+	file.Comments = comments
+	clearNodeComments(file)
+}
+
+// isDirective reports whether the comment is read by the toolchain
+func isDirective(text string) bool {
+	if constraint.IsGoBuild(text) {
+		return false
+	}
+	for _, prefix := range directivePrefixes {
+		if strings.HasPrefix(text, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// clearNodeComments detaches doc and line comments from the nodes of file.
+// the printer falls back to them when the file has no comments left, kept
+// comments are printed from the file's list
+func clearNodeComments(file *ast.File) {
+	file.Doc = nil
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Field:
+			n.Doc, n.Comment = nil, nil
+		case *ast.GenDecl:
+			n.Doc = nil
+		case *ast.FuncDecl:
+			n.Doc = nil
+		case *ast.ImportSpec:
+			n.Doc, n.Comment = nil, nil
+		case *ast.ValueSpec:
+			n.Doc, n.Comment = nil, nil
+		case *ast.TypeSpec:
+			n.Doc, n.Comment = nil, nil
+		}
+		return true
+	})
+}
+
+// linknameNames collects the local names of //go:linkname directives, the
+// directive names them in a comment renaming doesn't reach
+func linknameNames(p *loadedPackage) map[string]struct{} {
+	names := make(map[string]struct{})
+	for _, file := range p.files {
+		for _, group := range file.Comments {
+			for _, c := range group.List {
+				fields := strings.Fields(c.Text)
+				if len(fields) >= 2 && fields[0] == "//go:linkname" {
+					names[fields[1]] = struct{}{}
+				}
+			}
+		}
+	}
+	return names
+}
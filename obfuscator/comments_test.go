@@ -0,0 +1,181 @@
+package obfuscator
+
+import (
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIsDirective(t *testing.T) {
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{"//go:noinline", true},
+		{"//go:embed data.txt", true},
+		{"//line lib.go:10", true},
+		{"/*line lib.go:10:1*/", true},
+		{"//export callback", true},
+		{"//go:build linux", false},
+		{"// +build linux", false},
+		{"// go:noinline", false},
+		{"// Copyright Acme Corp", false},
+		{"/* TODO */", false},
+	}
+	for _, test := range tests {
+		if got := isDirective(test.text); got != test.want {
+			t.Errorf("isDirective(%q) = %v, want %v", test.text, got, test.want)
+		}
+	}
+}
+
+func TestRewriteStripsComments(t *testing.T) {
+	if _, err := exec.LookPath("gcc"); err != nil || !build.Default.CgoEnabled {
+		t.Skip("cgo isn't available")
+	}
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"go.mod": "module example.com/comments\n\ngo 1.16\n",
+		"cmd/app/main.go": `package main
+
+import (
+	"fmt"
+
+	"example.com/comments/lib"
+)
+
+func main() { fmt.Println(lib.Data(), lib.One()) }
+`,
+		"lib/data.txt": "hello",
+		"lib/lib.go": `// Copyright Acme Corp
+// TODO(alice): remove before release
+
+//go:build !never
+// +build !never
+
+// Package lib is documented
+package lib
+
+import (
+	_ "embed"
+	_ "unsafe"
+)
+
+//go:embed data.txt
+var data string
+
+//go:linkname nanotime runtime.nanotime
+func nanotime() int64
+
+// Data returns the embedded file
+//go:noinline
+func Data() string { // trailing remark
+	if nanotime() == 0 {
+		return ""
+	}
+	return data
+}
+
+//line lib.go:100
+func later() {}
+`,
+		"lib/cgo.go": `package lib
+
+/*
+static int one(void) { return 1; }
+*/
+import "C"
+
+// One is computed in C
+func One() int { later(); return int(C.one()) }
+`,
+		"lib/export.go": `package lib
+
+import "C"
+
+// exported is called from C
+//export exported
+func exported() {}
+`,
+	})
+
+	for _, strip := range []bool{false, true} {
+		target := t.TempDir()
+		out := runTarget(t, Options{
+			SrcPath:           filepath.Join(root, "cmd", "app"),
+			RootPath:          root,
+			TargetPath:        target,
+			RenameIdentifiers: true,
+			StripComments:     strip,
+		})
+		if out != "hello 1\n" {
+			t.Errorf("strip %v: target printed %q, want %q", strip, out, "hello 1\n")
+		}
+
+		var sources strings.Builder
+		for _, name := range targetFiles(t, target) {
+			if strings.HasSuffix(name, ".go") {
+				data, err := ioutil.ReadFile(filepath.Join(target, filepath.FromSlash(name)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				sources.Write(data)
+			}
+		}
+		for _, text := range []string{
+			"//go:build !never",
+			"// +build !never",
+			"//go:embed data.txt",
+			"//go:linkname nanotime runtime.nanotime",
+			"//go:noinline",
+			"//line lib.go:100",
+			"static int one(void)",
+			"//export exported",
+		} {
+			if !strings.Contains(sources.String(), text) {
+				t.Errorf("strip %v: %q was dropped", strip, text)
+			}
+		}
+		// comments are only stripped when asked to
+		for _, text := range []string{"Copyright", "TODO", "documented", "returns the embedded", "trailing remark", "computed in C", "called from C"} {
+			if kept := strings.Contains(sources.String(), text); kept == strip {
+				t.Errorf("strip %v: %q kept = %v", strip, text, kept)
+			}
+		}
+	}
+}
+
+func TestLinknameNames(t *testing.T) {
+	const src = `package p
+
+import _ "unsafe"
+
+//go:linkname nanotime runtime.nanotime
+func nanotime() int64
+
+//go:linkname pushed
+func pushed() {}
+
+// go:linkname spaced runtime.spaced
+func spaced() {}
+`
+	file, err := parser.ParseFile(token.NewFileSet(), "p.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := linknameNames(&loadedPackage{files: []*ast.File{file}})
+	if len(names) != 2 {
+		t.Errorf("got %v, want nanotime and pushed", names)
+	}
+	for _, name := range []string{"nanotime", "pushed"} {
+		if _, ok := names[name]; !ok {
+			t.Errorf("%s is missing from %v", name, names)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package obfuscator
+
+import (
+	"bufio"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var (
+	pathToken  = regexp.MustCompile(`[A-Za-z0-9_./\-]+`)
+	identToken = regexp.MustCompile(`\b[A-Za-z_][A-Za-z0-9_]*\b`)
+)
+
+// Deobfuscator rewrites aliased package paths, file names and identifiers in
+// logs and stack traces back to their original names. Line numbers are left
+// as they are
+type Deobfuscator struct {
+	importPaths map[string]string
+	files       map[string]string
+	idents      map[string]string
+}
+
+// NewDeobfuscator builds the reverse lookups for a mapping
+func NewDeobfuscator(m *Mapping) *Deobfuscator {
+	d := &Deobfuscator{
+		importPaths: make(map[string]string),
+		files:       make(map[string]string),
+		idents:      make(map[string]string),
+	}
+
+	// vendored packages have several original import paths, prefer the
+	// shortest since it's the one found in the package's own source
+	for name, importPath := range m.ImportPaths {
+		if prev, ok := d.importPaths[importPath]; !ok || len(name) < len(prev) || (len(name) == len(prev) && name < prev) {
+			d.importPaths[importPath] = name
+		}
+	}
+	for name, alias := range m.Files {
+		d.files[alias] = name
+	}
+	for key, alias := range m.Identifiers {
+		if name, ok := identifierName(key); ok {
+			d.idents[alias] = name
+		}
+	}
+	// encrypted tags name fields in encoded values
+	for name, alias := range m.Tags {
+		d.idents[alias] = name
+	}
+
+	return d
+}
+
+// identifierName recovers the original identifier from a namer key such as
+// example.com/pkg.field:name, or false if the key names something the
+// rewrite added, like the decoder of encrypted strings
+func identifierName(key string) (string, bool) {
+	name := key[strings.LastIndex(key, "/")+1:]
+	if i := strings.LastIndex(name, "."); i != -1 {
+		name = name[i+1:]
+	}
+	if strings.HasPrefix(name, "field:") || strings.HasPrefix(name, "method:") {
+		name = name[strings.Index(name, ":")+1:]
+	}
+	return name, !strings.Contains(name, ":")
+}
+
+// Deobfuscate copies r to w a line at a time replacing aliases
+func (d *Deobfuscator) Deobfuscate(r io.Reader, w io.Writer) error {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if len(line) > 0 {
+			if _, werr := io.WriteString(w, d.Line(line)); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// Line replaces the aliases in a single line
+func (d *Deobfuscator) Line(line string) string {
+	return pathToken.ReplaceAllStringFunc(line, d.token)
+}
+
+func (d *Deobfuscator) token(tok string) string {
+	// file aliases are unique so the directory they're in doesn't matter,
+	// the original is reported relative to its package import path
+	if strings.HasSuffix(tok, ".go") {
+		if name, ok := d.files[path.Base(tok)]; ok {
+			return name
+		}
+	}
+
+	// symbols are qualified with the package import path, replace the
+	// longest aliased import path the token starts with
+	prefix, rest := "", tok
+	for i := len(tok); i > 0; i-- {
+		if i < len(tok) && tok[i] != '.' && tok[i] != '/' {
+			continue
+		}
+		if name, ok := d.importPaths[tok[:i]]; ok {
+			prefix, rest = name, tok[i:]
+			break
+		}
+	}
+
+	return prefix + identToken.ReplaceAllStringFunc(rest, func(ident string) string {
+		if name, ok := d.idents[ident]; ok {
+			return name
+		}
+		return ident
+	})
+}
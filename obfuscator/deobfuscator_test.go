@@ -0,0 +1,98 @@
+package obfuscator
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDeobfuscatorLine(t *testing.T) {
+	d := NewDeobfuscator(testMapping())
+	tests := []struct {
+		line string
+		want string
+	}{
+		{
+			"panic: users not found",
+			"panic: users not found",
+		},
+		{
+			"wQkd/aKxe.Jdke(0xc000010000)",
+			"example.com/proj/users.Find(0xc000010000)",
+		},
+		{
+			"(*wQkd/aKxe.Jdke).Xlpa(...)",
+			"(*example.com/proj/users.Find).Save(...)",
+		},
+		{
+			"\t/target/src/wQkd/aKxe/mEoq.go:42 +0x1d",
+			"\texample.com/proj/users/users.go:42 +0x1d",
+		},
+		{
+			"\t/target/src/wQkd/aKxe/pTza_linux.go:7",
+			"\texample.com/proj/users/users_linux.go:7",
+		},
+		{
+			"wQkd/vendor/zzPx.Open()",
+			"example.com/dep.Open()",
+		},
+		{
+			"wQkd/aKxeX.Jdke",
+			"wQkd/aKxeX.Find",
+		},
+		{
+			`{"yeTr":"a@example.com","qwod":"x"}`,
+			`{"email":"a@example.com","name":"x"}`,
+		},
+		{
+			"Jdkeish",
+			"Jdkeish",
+		},
+	}
+	for _, test := range tests {
+		if got := d.Line(test.line); got != test.want {
+			t.Errorf("Line(%q) = %q, want %q", test.line, got, test.want)
+		}
+	}
+}
+
+func TestIdentifierName(t *testing.T) {
+	tests := []struct {
+		key  string
+		name string
+		ok   bool
+	}{
+		{"example.com/proj/users.Find", "Find", true},
+		{"example.com/proj/users.field:name", "name", true},
+		{"example.com/proj/users.method:save", "save", true},
+		{"field:Name", "Name", true},
+		{"method:Save", "Save", true},
+		{"example.com/proj/users.decoder:string", "", false},
+		{"example.com/proj/users.key:string", "", false},
+	}
+	for _, test := range tests {
+		name, ok := identifierName(test.key)
+		if ok != test.ok || (ok && name != test.name) {
+			t.Errorf("identifierName(%q) = %q, %v, want %q, %v", test.key, name, ok, test.name, test.ok)
+		}
+	}
+
+	// the aliases of added names are left as they are
+	m := testMapping()
+	m.Identifiers["example.com/proj/users.decoder:string"] = "vbNm"
+	if got := NewDeobfuscator(m).Line("wQkd/aKxe.vbNm(3)"); got != "example.com/proj/users.vbNm(3)" {
+		t.Errorf("decoder call deobfuscated to %q", got)
+	}
+}
+
+func TestDeobfuscate(t *testing.T) {
+	in := "goroutine 1 [running]:\nwQkd/aKxe.Jdke()\n\t/t/mEoq.go:3"
+	want := "goroutine 1 [running]:\nexample.com/proj/users.Find()\n\texample.com/proj/users/users.go:3"
+	var out bytes.Buffer
+	if err := NewDeobfuscator(testMapping()).Deobfuscate(strings.NewReader(in), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
@@ -0,0 +1,229 @@
+package obfuscator
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/token"
+	"go/types"
+	"strings"
+)
+
+// errors
+var (
+	ErrUnknownDirective = errors.New("unknown directive")
+)
+
+const (
+	// directivePrefix starts the comments the rewrite reads
+	directivePrefix = "//gobf:"
+
+	// keepDirective keeps the names declared by the package, declaration or
+	// field it's on, types keep the names of their fields and methods too
+	keepDirective = "//gobf:keep"
+	// keepStringsDirective leaves the strings of what it's on unencrypted
+	keepStringsDirective = "//gobf:keep-strings"
+	// encryptDirective encrypts the strings of what it's on, whether strings
+	// are encrypted or not
+	encryptDirective = "//gobf:encrypt"
+)
+
+// knownDirectives are the directives the rewrite understands
+var knownDirectives = map[string]struct{}{
+	keepDirective:        {},
+	keepStringsDirective: {},
+	encryptDirective:     {},
+	internalDirective:    {},
+	flattenDirective:     {},
+}
+
+// directiveName is the directive a comment holds, without its arguments, or
+// "" if it holds none
+func directiveName(text string) string {
+	if !strings.HasPrefix(text, directivePrefix) {
+		return ""
+	}
+	if i := strings.IndexAny(text, " \t"); i != -1 {
+		return text[:i]
+	}
+	return text
+}
+
+// checkDirectives reports the first directive in files that isn't known
+func (r *rewriter) checkDirectives(files []*ast.File) error {
+	for _, file := range files {
+		for _, group := range file.Comments {
+			for _, c := range group.List {
+				name := directiveName(c.Text)
+				if name == "" {
+					continue
+				}
+				if _, ok := knownDirectives[name]; !ok {
+					pos := r.fset.Position(c.Pos()).String()
+					return r.fail(ParseError, pos, fmt.Errorf("%s: %v", name, ErrUnknownDirective))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// hasEncryptDirective reports whether any of files asks for strings to be
+// encrypted
+func hasEncryptDirective(files []*ast.File) bool {
+	for _, file := range files {
+		for _, group := range file.Comments {
+			for _, c := range group.List {
+				if directiveName(c.Text) == encryptDirective {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// parsedFiles are the files of pkgs parsed ahead of the rewrite
+func (r *rewriter) parsedFiles(pkgs []*build.Package) []*ast.File {
+	var files []*ast.File
+	for _, pkg := range pkgs {
+		var names []string
+		names = append(names, pkg.GoFiles...)
+		names = append(names, pkg.CgoFiles...)
+		if r.options.Tests && r.isInternal(pkg) {
+			names = append(names, pkg.TestGoFiles...)
+			names = append(names, pkg.XTestGoFiles...)
+		}
+		prefixDirectory(pkg.Dir, names)
+		for _, name := range names {
+			if file, ok := r.parsed[name]; ok {
+				files = append(files, file)
+			}
+		}
+	}
+	return files
+}
+
+// stringDirectiveNames are the directives that decide whether strings are
+// encrypted
+var stringDirectiveNames = []string{keepStringsDirective, encryptDirective}
+
+// collectDirectives reads the directives of the files of src before anything
+// is renamed. Kept identifiers are recorded by their keys, string directives
+// by the positions of the expressions they cover
+func (r *rewriter) collectDirectives(src *loadedPackage) {
+	// a directive on the package clause of any file covers them all
+	var pkgDirective string
+	for _, file := range src.files {
+		if hasDirective(file.Doc, keepDirective) {
+			for _, obj := range src.info.Defs {
+				r.keepObject(obj)
+			}
+		}
+		for _, directive := range stringDirectiveNames {
+			if hasDirective(file.Doc, directive) {
+				pkgDirective = directive
+			}
+		}
+	}
+
+	for _, file := range src.files {
+		directives := make(map[ast.Node]string)
+		cmap := ast.NewCommentMap(r.fset, file, file.Comments)
+		for node, groups := range cmap {
+			if _, ok := node.(*ast.File); ok {
+				continue
+			}
+			for _, group := range groups {
+				if hasDirective(group, keepDirective) {
+					r.keepNode(src, node)
+				}
+				for _, directive := range stringDirectiveNames {
+					if hasDirective(group, directive) {
+						directives[node] = directive
+					}
+				}
+			}
+		}
+		r.resolveStringDirectives(file, pkgDirective, directives)
+	}
+}
+
+// resolveStringDirectives records the string directive of every expression
+// in file, that of the innermost node around it that has one
+func (r *rewriter) resolveStringDirectives(file *ast.File, pkgDirective string, directives map[ast.Node]string) {
+	stack := []string{pkgDirective}
+	ast.Inspect(file, func(n ast.Node) bool {
+		if n == nil {
+			stack = stack[:len(stack)-1]
+			return true
+		}
+		directive := stack[len(stack)-1]
+		if d, ok := directives[n]; ok {
+			directive = d
+		}
+		stack = append(stack, directive)
+		if _, ok := n.(ast.Expr); ok && directive != "" {
+			r.stringDirectives[n.Pos()] = directive
+		}
+		return true
+	})
+}
+
+// keepObject keeps the name of obj wherever it's declared or used
+func (r *rewriter) keepObject(obj types.Object) {
+	if obj == nil {
+		return
+	}
+	if key, ok := r.identifierKey(obj); ok {
+		r.keepKeys[key] = struct{}{}
+	}
+}
+
+// keepNode keeps the names declared by a declaration, spec or field
+func (r *rewriter) keepNode(src *loadedPackage, node ast.Node) {
+	keep := func(idents []*ast.Ident) {
+		for _, ident := range idents {
+			r.keepObject(src.info.Defs[ident])
+		}
+	}
+	switch node := node.(type) {
+	case *ast.FuncDecl:
+		keep([]*ast.Ident{node.Name})
+	case *ast.GenDecl:
+		for _, spec := range node.Specs {
+			r.keepNode(src, spec)
+		}
+	case *ast.ValueSpec:
+		keep(node.Names)
+	case *ast.Field:
+		keep(node.Names)
+	case *ast.TypeSpec:
+		keep([]*ast.Ident{node.Name})
+		ast.Inspect(node.Type, func(n ast.Node) bool {
+			if field, ok := n.(*ast.Field); ok {
+				keep(field.Names)
+			}
+			return true
+		})
+		if obj, ok := src.info.Defs[node.Name].(*types.TypeName); ok {
+			methods := types.NewMethodSet(types.NewPointer(obj.Type()))
+			for i := 0; i < methods.Len(); i++ {
+				r.keepObject(methods.At(i).Obj())
+			}
+		}
+	}
+}
+
+// encryptsLiteral reports whether the string literal at pos in pkg is
+// encrypted
+func (r *rewriter) encryptsLiteral(pkg *build.Package, pos token.Pos) bool {
+	switch r.stringDirectives[pos] {
+	case encryptDirective:
+		return true
+	case keepStringsDirective:
+		return false
+	}
+	return r.options.EncryptStrings && r.selected(pkg)
+}
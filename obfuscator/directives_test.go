@@ -0,0 +1,115 @@
+package obfuscator
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDirectiveScopes(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"go.mod": "module example.com/dirs\n\ngo 1.16\n",
+		"main.go": `package main
+
+import (
+	"fmt"
+
+	"example.com/dirs/a"
+	"example.com/dirs/b"
+	"example.com/dirs/c"
+)
+
+func main() {
+	fmt.Println(a.Config{Name: "x"}.Describe(), a.Version(), b.Config(), b.Plain(), b.Hidden(), c.One(), c.Two())
+}
+`,
+		"a/a.go": `package a
+
+//gobf:keep
+type Config struct {
+	Name string
+}
+
+// Describe describes
+func (c Config) Describe() string { return "config-" + c.Name }
+
+// Version versions
+func Version() string { return "v1" }
+`,
+		"b/b.go": `package b
+
+// Config shares its name with a kept type
+func Config() string { return "b" }
+
+// Plain keeps its strings but one
+//gobf:keep-strings
+func Plain() string {
+	s := "plain-text"
+	//gobf:encrypt
+	t := "secret-text"
+	return s + t
+}
+
+// Hidden hides
+func Hidden() string { return "hidden-text" }
+`,
+		"c/one.go": `//gobf:keep-strings
+package c
+
+// One ones
+func One() string { return "c-one-text" }
+`,
+		"c/two.go": `package c
+
+// Two twos
+func Two() string { return "c-two-text" }
+`,
+	})
+
+	target := t.TempDir()
+	out := runTarget(t, Options{
+		SrcPath:           root,
+		RootPath:          root,
+		TargetPath:        target,
+		RenameIdentifiers: true,
+		RenameExported:    true,
+		EncryptStrings:    true,
+		StripComments:     true,
+	})
+	if want := "config-x v1 b plain-textsecret-text hidden-text c-one-text c-two-text\n"; out != want {
+		t.Errorf("target printed %q, want %q", out, want)
+	}
+
+	var sources strings.Builder
+	for _, name := range targetFiles(t, target) {
+		if strings.HasSuffix(name, ".go") {
+			data, err := ioutil.ReadFile(filepath.Join(target, filepath.FromSlash(name)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			sources.Write(data)
+		}
+	}
+	tests := []struct {
+		text string
+		kept bool
+	}{
+		{"type Config struct", true},
+		{"Name string", true},
+		{"Describe()", true},
+		{"func Config()", false},
+		{"Version", false},
+		{`"plain-text"`, true},
+		{`"secret-text"`, false},
+		{`"hidden-text"`, false},
+		{`"c-one-text"`, true},
+		{`"c-two-text"`, true},
+	}
+	for _, test := range tests {
+		if kept := strings.Contains(sources.String(), test.text); kept != test.kept {
+			t.Errorf("%s kept = %v, want %v", test.text, kept, test.kept)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package obfuscator
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ErrorKind classifies the failures returned by Rewrite
+type ErrorKind int
+
+// kinds of errors
+const (
+	// OptionsError is an invalid option or an unreadable previous mapping
+	OptionsError ErrorKind = iota + 1
+	// ResolveError is a package or module that couldn't be found
+	ResolveError
+	// ParseError is a go file that couldn't be parsed
+	ParseError
+	// CheckError is a package that failed to type-check
+	CheckError
+	// WriteError is a failure to write the target tree or the mapping
+	WriteError
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case OptionsError:
+		return "invalid options"
+	case ResolveError:
+		return "cannot resolve"
+	case ParseError:
+		return "cannot parse"
+	case CheckError:
+		return "cannot type-check"
+	case WriteError:
+		return "cannot write"
+	}
+	return "error"
+}
+
+// Error is a failure to rewrite the project. Path is the original file,
+// directory or import path that failed and ImportChain the packages being
+// rewritten at the time, from the main package to the one that failed
+type Error struct {
+	Kind        ErrorKind
+	Path        string
+	ImportChain []string
+	Err         error
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%s %s: %v", e.Kind, e.Path, e.Err)
+	if e.Path == "" {
+		msg = fmt.Sprintf("%s: %v", e.Kind, e.Err)
+	}
+	if len(e.ImportChain) > 0 {
+		msg += fmt.Sprintf(" (import chain: %s)", strings.Join(e.ImportChain, " -> "))
+	}
+	return msg
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Errors are the failures collected across the dependency graph when
+// Options.CollectErrors is set, in the order they happened
+type Errors []error
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// fail wraps err with the package being rewritten, errors that already
+// carry a location are returned as is
+func (r *rewriter) fail(kind ErrorKind, path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*Error); ok {
+		return err
+	}
+	return &Error{
+		Kind:        kind,
+		Path:        path,
+		ImportChain: r.importChain(),
+		Err:         err,
+	}
+}
+
+// importChain lists the packages being rewritten or checked. a package is
+// usually checked while it's being rewritten so repeats are dropped
+func (r *rewriter) importChain() []string {
+	var chain []string
+	for _, importPath := range r.chain {
+		if len(chain) == 0 || chain[len(chain)-1] != importPath {
+			chain = append(chain, importPath)
+		}
+	}
+	return chain
+}
@@ -0,0 +1,187 @@
+package obfuscator
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRewriteErrors(t *testing.T) {
+	const main = `package main
+
+import "example.com/errs/lib"
+
+func main() { lib.Run() }
+`
+	tests := []struct {
+		name  string
+		lib   map[string]string
+		kind  ErrorKind
+		path  string
+		chain []string
+	}{
+		{
+			name: "missing import",
+			lib: map[string]string{
+				"lib/lib.go": "package lib\n\nimport \"example.com/errs/missing\"\n\nfunc Run() { missing.Run() }\n",
+			},
+			kind:  ResolveError,
+			path:  "example.com/errs/missing",
+			chain: []string{"example.com/errs/cmd/app", "example.com/errs/lib"},
+		},
+		{
+			name: "syntax error",
+			lib: map[string]string{
+				"lib/lib.go":    "package lib\n\nfunc Run() {}\n",
+				"lib/broken.go": "package lib\n\nfunc broken( {\n",
+			},
+			kind:  ParseError,
+			path:  filepath.Join("lib", "broken.go"),
+			chain: []string{"example.com/errs/cmd/app", "example.com/errs/lib"},
+		},
+		{
+			name: "type error",
+			lib: map[string]string{
+				"lib/lib.go": "package lib\n\nvar count int = \"one\"\n\nfunc Run() {}\n",
+			},
+			kind:  CheckError,
+			path:  "lib",
+			chain: []string{"example.com/errs/cmd/app", "example.com/errs/lib"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root := t.TempDir()
+			files := map[string]string{
+				"go.mod":          "module example.com/errs\n\ngo 1.16\n",
+				"cmd/app/main.go": main,
+			}
+			for name, data := range test.lib {
+				files[name] = data
+			}
+			writeTree(t, root, files)
+
+			_, err := Rewrite(Options{
+				SrcPath:           filepath.Join(root, "cmd", "app"),
+				RootPath:          root,
+				TargetPath:        t.TempDir(),
+				RenameIdentifiers: true,
+			})
+			var rerr *Error
+			if !errors.As(err, &rerr) {
+				t.Fatalf("got %v, want an *Error", err)
+			}
+			if rerr.Kind != test.kind {
+				t.Errorf("kind = %s, want %s", rerr.Kind, test.kind)
+			}
+			if !strings.HasSuffix(rerr.Path, test.path) {
+				t.Errorf("path = %s, want a path ending in %s", rerr.Path, test.path)
+			}
+			if strings.Join(rerr.ImportChain, " ") != strings.Join(test.chain, " ") {
+				t.Errorf("import chain = %v, want %v", rerr.ImportChain, test.chain)
+			}
+			if rerr.Err == nil {
+				t.Error("the underlying error is missing")
+			}
+		})
+	}
+}
+
+func TestRewriteOptionsError(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"go.mod":          "module example.com/errs\n\ngo 1.16\n",
+		"cmd/app/main.go": "package main\n\nfunc main() {}\n",
+	})
+	_, err := Rewrite(Options{
+		SrcPath:    filepath.Join(root, "cmd", "app"),
+		RootPath:   root,
+		TargetPath: t.TempDir(),
+		Platforms:  []string{"linux"},
+	})
+	var rerr *Error
+	if !errors.As(err, &rerr) || rerr.Kind != OptionsError {
+		t.Fatalf("got %v, want an options error", err)
+	}
+	if !errors.Is(err, ErrPlatform) {
+		t.Errorf("%v doesn't unwrap to %v", err, ErrPlatform)
+	}
+}
+
+func TestErrorMessage(t *testing.T) {
+	err := &Error{
+		Kind:        CheckError,
+		Path:        "/src/lib",
+		ImportChain: []string{"example.com/app", "example.com/app/lib"},
+		Err:         errors.New("undefined: x"),
+	}
+	const want = "cannot type-check /src/lib: undefined: x (import chain: example.com/app -> example.com/app/lib)"
+	if err.Error() != want {
+		t.Errorf("got %q, want %q", err.Error(), want)
+	}
+
+	err.ImportChain = nil
+	if got := err.Error(); got != "cannot type-check /src/lib: undefined: x" {
+		t.Errorf("got %q without an import chain", got)
+	}
+}
+
+func TestRewriteCollectErrors(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"go.mod": "module example.com/errs\n\ngo 1.16\n",
+		"cmd/app/main.go": `package main
+
+import (
+	"example.com/errs/first"
+	"example.com/errs/fine"
+	"example.com/errs/second"
+)
+
+func main() { first.Run(); second.Run(); fine.Run() }
+`,
+		"first/first.go":   "package first\n\nvar count int = \"one\"\n\nfunc Run() {}\n",
+		"second/second.go": "package second\n\nfunc Run() { undefined() }\n",
+		"fine/fine.go":     "package fine\n\nfunc Run() {}\n",
+	})
+	options := Options{
+		SrcPath:           filepath.Join(root, "cmd", "app"),
+		RootPath:          root,
+		TargetPath:        t.TempDir(),
+		RenameIdentifiers: true,
+	}
+
+	_, err := Rewrite(options)
+	if _, ok := err.(*Error); !ok {
+		t.Fatalf("got %v, want the first failure alone", err)
+	}
+
+	options.CollectErrors = true
+	options.TargetPath = t.TempDir()
+	_, err = Rewrite(options)
+	errs, ok := err.(Errors)
+	if !ok {
+		t.Fatalf("got %v, want Errors", err)
+	}
+	var paths []string
+	for _, err := range errs {
+		var rerr *Error
+		if !errors.As(err, &rerr) || rerr.Kind != CheckError {
+			t.Errorf("got %v, want a type-check error", err)
+			continue
+		}
+		paths = append(paths, filepath.Base(rerr.Path))
+	}
+	if got := strings.Join(paths, " "); !strings.Contains(got, "first") || !strings.Contains(got, "second") {
+		t.Errorf("failed packages = %v, want first and second", paths)
+	}
+	if lines := strings.Count(errs.Error(), "\n") + 1; lines != len(errs) {
+		t.Errorf("message has %d lines for %d errors", lines, len(errs))
+	}
+
+	// the rest of the graph is still written
+	if files := targetFiles(t, options.TargetPath); len(files) < 4 {
+		t.Errorf("target has %v, want every package written", files)
+	}
+}
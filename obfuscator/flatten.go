@@ -0,0 +1,942 @@
+package obfuscator
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/token"
+	"go/types"
+	"sort"
+	"strconv"
+)
+
+// errors
+var (
+	ErrFlatten = errors.New("function can't be flattened")
+)
+
+const (
+	// flattenDirective flattens the control flow of the function it's on
+	flattenDirective = "//gobf:flatten"
+)
+
+// flattenTarget is a function selected for flattening
+type flattenTarget struct {
+	// name is the function's import path and name as rules match it
+	name string
+	// directive is set when a directive selected the function, failing to
+	// flatten it is an error rather than leaving it as it is
+	directive bool
+}
+
+// funcName is the import path and name of a function joined by a dot,
+// methods are qualified by their receiver type
+func funcName(importPath string, fd *ast.FuncDecl) string {
+	name := fd.Name.Name
+	if fd.Recv != nil && len(fd.Recv.List) > 0 {
+		t := fd.Recv.List[0].Type
+		for {
+			if star, ok := t.(*ast.StarExpr); ok {
+				t = star.X
+			} else if paren, ok := t.(*ast.ParenExpr); ok {
+				t = paren.X
+			} else if index, ok := t.(*ast.IndexExpr); ok {
+				t = index.X
+			} else if index, ok := t.(*ast.IndexListExpr); ok {
+				t = index.X
+			} else {
+				break
+			}
+		}
+		if ident, ok := t.(*ast.Ident); ok {
+			name = ident.Name + "." + name
+		}
+	}
+	return importPath + "." + name
+}
+
+// flattenTarget reports whether fd in pkg is to be flattened, by a
+// //gobf:flatten directive or a Flatten rule. names are read before the
+// package is renamed
+func (r *rewriter) flattenTarget(pkg *build.Package, fd *ast.FuncDecl) (*flattenTarget, bool) {
+	if fd.Body == nil || !r.selected(pkg) {
+		return nil, false
+	}
+	name := funcName(pkg.ImportPath, fd)
+	if hasDirective(fd.Doc, flattenDirective) {
+		return &flattenTarget{name: name, directive: true}, true
+	}
+	if r.policy.flatten.match(name) || r.policy.flatten.match(funcName(unvendoredPath(pkg.ImportPath), fd)) {
+		return &flattenTarget{name: name}, true
+	}
+	return nil, false
+}
+
+// hasFlattenTargets reports whether any function of files is to be
+// flattened
+func (r *rewriter) hasFlattenTargets(pkg *build.Package, files []*ast.File) bool {
+	for _, file := range files {
+		for _, decl := range file.Decls {
+			if fd, ok := decl.(*ast.FuncDecl); ok {
+				if _, ok := r.flattenTarget(pkg, fd); ok {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// collectFlattenTargets records the functions of src to flatten
+func (r *rewriter) collectFlattenTargets(src *loadedPackage) {
+	for _, file := range src.files {
+		for _, decl := range file.Decls {
+			fd, ok := decl.(*ast.FuncDecl)
+			if !ok {
+				continue
+			}
+			if _, ok := r.flattenTargets[fd]; ok {
+				continue
+			}
+			if target, ok := r.flattenTarget(src.build, fd); ok {
+				r.flattenTargets[fd] = target
+			}
+		}
+	}
+}
+
+// flattenFunctions flattens the selected functions of a package as checked
+// for every platform. It runs after renaming so the types it names are
+// aliased already, files shared by platforms are flattened once
+func (r *rewriter) flattenFunctions(variants []*loadedPackage) error {
+	var files []*ast.File
+	owners := make(map[*ast.File]*loadedPackage)
+	for _, variant := range variants {
+		for _, file := range variant.files {
+			if _, ok := owners[file]; !ok {
+				owners[file] = variant
+				files = append(files, file)
+			}
+		}
+	}
+
+	for _, file := range files {
+		ast.Inspect(file, func(n ast.Node) bool {
+			if ident, ok := n.(*ast.Ident); ok {
+				r.namer.Reserve(ident.Name)
+			}
+			return true
+		})
+	}
+
+	for _, file := range files {
+		for _, decl := range file.Decls {
+			fd, ok := decl.(*ast.FuncDecl)
+			if !ok {
+				continue
+			}
+			target, ok := r.flattenTargets[fd]
+			if !ok {
+				continue
+			}
+			f := &flattener{
+				r:       r,
+				p:       owners[file],
+				file:    file,
+				fn:      fd,
+				key:     "flatten:" + target.name,
+				labels:  make(map[string]int),
+				hoisted: make(map[types.Object]struct{}),
+				names:   make(map[string]struct{}),
+			}
+			reason, err := f.flatten()
+			if err != nil {
+				return err
+			}
+			if reason != "" {
+				if target.directive {
+					pos := r.fset.Position(fd.Pos()).String()
+					return r.fail(CheckError, pos, fmt.Errorf("%s: %v, %s", target.name, ErrFlatten, reason))
+				}
+				continue
+			}
+			r.plan.Flattened = append(r.plan.Flattened, target.name)
+		}
+	}
+	return nil
+}
+
+// flattener rewrites the body of one function into a loop that dispatches
+// on a state variable. Top-level statements and the branches of top-level if
+// statements become cases, each moving to the next by xoring the state with
+// the difference between the two so the order can't be read off the cases.
+// Declarations are hoisted ahead of the loop, gotos to top-level labels
+// become state changes. Returns, defers and closures are left where they are
+// so results, recover and captured variables behave as before
+type flattener struct {
+	r    *rewriter
+	p    *loadedPackage
+	file *ast.File
+	fn   *ast.FuncDecl
+	key  string
+
+	units []*flatUnit
+	// labels are the units top-level labels lead to
+	labels map[string]int
+	// decls are hoisted type, const and var declarations
+	decls []ast.Stmt
+	// hoisted are the objects declared by top-level statements and names
+	// their names
+	hoisted map[types.Object]struct{}
+	names   map[string]struct{}
+	// outer are names hoisted type expressions refer to outside the body
+	outer []string
+	// defines are the top-level short variable declarations, they become
+	// assignments once nothing can stop the function being flattened
+	defines []*ast.AssignStmt
+
+	states []int
+	state  string
+	loop   string
+	jumped bool
+}
+
+// flatUnit is one case of the dispatcher
+type flatUnit struct {
+	stmts []ast.Stmt
+	// branches test cond and go to then or els instead of running stmts
+	cond      ast.Expr
+	then, els int
+	// next is the unit run after this one, the end unit after the last
+	next int
+}
+
+// flatten rewrites the function, or returns why it can't be
+func (f *flattener) flatten() (string, error) {
+	body := f.fn.Body.List
+	if len(body) < 2 {
+		return "", nil
+	}
+
+	// top-level labels and whether a goto jumps back to one
+	tops := make(map[string]int)
+	for i, stmt := range body {
+		for {
+			labeled, ok := stmt.(*ast.LabeledStmt)
+			if !ok {
+				break
+			}
+			tops[labeled.Label.Name] = i
+			stmt = labeled.Stmt
+		}
+	}
+	backward := false
+	for i, stmt := range body {
+		inspectBody(stmt, func(n ast.Node) {
+			if b, ok := n.(*ast.BranchStmt); ok && b.Tok == token.GOTO {
+				if to, ok := tops[b.Label.Name]; ok && to <= i {
+					backward = true
+				}
+			}
+		})
+	}
+
+	var starts []int
+	for _, stmt := range body {
+		starts = append(starts, len(f.units))
+		if reason := f.addUnits(stmt, backward); reason != "" {
+			return reason, nil
+		}
+	}
+	end := len(f.units)
+	for i, start := range starts {
+		next := end
+		if i+1 < len(starts) {
+			next = starts[i+1]
+		}
+		stop := end
+		if i+1 < len(starts) {
+			stop = starts[i+1]
+		}
+		for _, u := range f.units[start:stop] {
+			u.next = next
+			if u.cond != nil && u.els < 0 {
+				u.els = next
+			}
+		}
+	}
+	results := f.fn.Type.Results != nil && len(f.fn.Type.Results.List) > 0
+	if !results {
+		f.units = append(f.units, &flatUnit{stmts: []ast.Stmt{&ast.ReturnStmt{}}, next: -1})
+	}
+	for _, u := range f.units {
+		if u.cond != nil && (u.then >= len(f.units) || u.els >= len(f.units)) {
+			return "a branch falls off the end of the function", nil
+		}
+	}
+
+	if reason := f.checkScopes(backward); reason != "" {
+		return reason, nil
+	}
+
+	var err error
+	f.state, err = f.r.namer.AliasIdent(f.key+":state", false)
+	if err != nil {
+		return "", err
+	}
+	f.loop, err = f.r.namer.AliasIdent(f.key+":loop", false)
+	if err != nil {
+		return "", err
+	}
+	if err := f.newStates(); err != nil {
+		return "", err
+	}
+
+	for _, s := range f.defines {
+		s.Tok = token.ASSIGN
+	}
+	var clauses []*ast.CaseClause
+	for i, u := range f.units {
+		clause := &ast.CaseClause{List: []ast.Expr{f.stateLit(f.states[i])}}
+		if u.cond != nil {
+			clause.Body = []ast.Stmt{&ast.IfStmt{
+				Cond: u.cond,
+				Body: &ast.BlockStmt{List: []ast.Stmt{f.jump(i, u.then)}},
+				Else: &ast.BlockStmt{List: []ast.Stmt{f.jump(i, u.els)}},
+			}}
+		} else {
+			terminal := len(u.stmts) > 0 && f.isTerminal(u.stmts[len(u.stmts)-1])
+			f.replaceGotos(u.stmts, i)
+			clause.Body = u.stmts
+			// the printer separates statements by the lines between them
+			if len(u.stmts) > 0 {
+				clause.Case = u.stmts[0].Pos()
+				clause.Colon = u.stmts[0].Pos()
+			}
+			// the end of a function with results is never reached
+			if !terminal && u.next >= 0 && u.next < len(f.units) {
+				clause.Body = append(clause.Body, f.jump(i, u.next))
+			}
+		}
+		clauses = append(clauses, clause)
+	}
+	sort.Slice(clauses, func(i, j int) bool {
+		return clauses[i].List[0].(*ast.BasicLit).Value < clauses[j].List[0].(*ast.BasicLit).Value
+	})
+	var list []ast.Stmt
+	for _, clause := range clauses {
+		list = append(list, clause)
+	}
+
+	var loop ast.Stmt = &ast.ForStmt{
+		Body: &ast.BlockStmt{List: []ast.Stmt{&ast.SwitchStmt{
+			Tag:  ast.NewIdent(f.state),
+			Body: &ast.BlockStmt{List: list},
+		}}},
+	}
+	if f.jumped {
+		loop = &ast.LabeledStmt{Label: ast.NewIdent(f.loop), Stmt: loop}
+	}
+	init := &ast.AssignStmt{
+		Lhs: []ast.Expr{ast.NewIdent(f.state)},
+		Tok: token.DEFINE,
+		Rhs: []ast.Expr{f.stateLit(f.states[0])},
+	}
+
+	// comments inside the body can't keep their place
+	var comments []*ast.CommentGroup
+	for _, group := range f.file.Comments {
+		if group.Pos() < f.fn.Body.Lbrace || group.Pos() > f.fn.Body.Rbrace {
+			comments = append(comments, group)
+		}
+	}
+	f.file.Comments = comments
+
+	f.fn.Body.List = append(append(f.decls, init), loop)
+	return "", nil
+}
+
+// addUnits turns a top-level statement into units, hoisting what it
+// declares
+func (f *flattener) addUnits(stmt ast.Stmt, backward bool) string {
+	index := len(f.units)
+	var labels []*ast.Ident
+	for {
+		labeled, ok := stmt.(*ast.LabeledStmt)
+		if !ok {
+			break
+		}
+		f.labels[labeled.Label.Name] = index
+		labels = append(labels, labeled.Label)
+		stmt = labeled.Stmt
+	}
+	// labels break and continue refer to stay on their statement
+	for i := len(labels) - 1; i >= 0; i-- {
+		if branchesTo(stmt, labels[i].Name) {
+			stmt = &ast.LabeledStmt{Label: labels[i], Stmt: stmt}
+		}
+	}
+
+	unit := &flatUnit{}
+	switch s := stmt.(type) {
+	case *ast.DeclStmt:
+		gd := s.Decl.(*ast.GenDecl)
+		if gd.Tok != token.VAR {
+			for _, spec := range gd.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					f.hoist(spec.Name)
+				case *ast.ValueSpec:
+					for _, name := range spec.Names {
+						f.hoist(name)
+					}
+				}
+			}
+			f.decls = append(f.decls, s)
+			break
+		}
+		for _, spec := range gd.Specs {
+			spec := spec.(*ast.ValueSpec)
+			var names []*ast.Ident
+			for _, name := range spec.Names {
+				if name.Name == "_" {
+					continue
+				}
+				names = append(names, name)
+				f.hoist(name)
+				if spec.Type != nil {
+					continue
+				}
+				obj, ok := f.p.info.Defs[name].(*types.Var)
+				if !ok {
+					return "a variable has no type"
+				}
+				t, ok := f.typeExpr(obj.Type())
+				if !ok {
+					return fmt.Sprintf("the type of %s can't be named ahead of the body", name.Name)
+				}
+				f.decls = append(f.decls, varDecl(name.Name, t))
+			}
+			if spec.Type != nil && len(names) > 0 {
+				var idents []*ast.Ident
+				for _, name := range names {
+					idents = append(idents, ast.NewIdent(name.Name))
+				}
+				f.decls = append(f.decls, &ast.DeclStmt{Decl: &ast.GenDecl{
+					Tok:   token.VAR,
+					Specs: []ast.Spec{&ast.ValueSpec{Names: idents, Type: spec.Type}},
+				}})
+			}
+
+			var lhs []ast.Expr
+			for _, name := range spec.Names {
+				lhs = append(lhs, name)
+			}
+			switch {
+			case len(spec.Values) > 0:
+				unit.stmts = append(unit.stmts, &ast.AssignStmt{Lhs: lhs, Tok: token.ASSIGN, Rhs: spec.Values})
+			case backward:
+				// a declaration run again zeroes its variables again
+				for _, name := range names {
+					unit.stmts = append(unit.stmts, &ast.AssignStmt{
+						Lhs: []ast.Expr{ast.NewIdent(name.Name)},
+						Tok: token.ASSIGN,
+						Rhs: []ast.Expr{&ast.StarExpr{X: &ast.CallExpr{
+							Fun:  ast.NewIdent("new"),
+							Args: []ast.Expr{spec.Type},
+						}}},
+					})
+					f.outer = append(f.outer, "new")
+				}
+			}
+		}
+	case *ast.AssignStmt:
+		if s.Tok == token.DEFINE {
+			for _, lhs := range s.Lhs {
+				ident, ok := lhs.(*ast.Ident)
+				if !ok || ident.Name == "_" {
+					continue
+				}
+				obj, ok := f.p.info.Defs[ident].(*types.Var)
+				if !ok || obj == nil {
+					continue
+				}
+				f.hoist(ident)
+				t, ok := f.typeExpr(obj.Type())
+				if !ok {
+					return fmt.Sprintf("the type of %s can't be named ahead of the body", ident.Name)
+				}
+				f.decls = append(f.decls, varDecl(ident.Name, t))
+			}
+			f.defines = append(f.defines, s)
+		}
+		unit.stmts = []ast.Stmt{s}
+	case *ast.IfStmt:
+		if s.Init != nil {
+			unit.stmts = []ast.Stmt{s}
+			break
+		}
+		// the branch and its blocks, which keep their own scopes as cases
+		unit.cond = s.Cond
+		unit.then = index + 1
+		unit.els = -1
+		f.units = append(f.units, unit, &flatUnit{stmts: s.Body.List})
+		switch els := s.Else.(type) {
+		case *ast.BlockStmt:
+			unit.els = index + 2
+			f.units = append(f.units, &flatUnit{stmts: els.List})
+		case ast.Stmt:
+			unit.els = index + 2
+			f.units = append(f.units, &flatUnit{stmts: []ast.Stmt{els}})
+		}
+		return ""
+	default:
+		unit.stmts = []ast.Stmt{s}
+	}
+	f.units = append(f.units, unit)
+	return ""
+}
+
+// hoist records an object declared by a top-level statement
+func (f *flattener) hoist(ident *ast.Ident) {
+	if obj := f.p.info.Defs[ident]; obj != nil {
+		f.hoisted[obj] = struct{}{}
+	}
+	f.names[ident.Name] = struct{}{}
+}
+
+// checkScopes reports why hoisting would change what a name refers to. A
+// name used before it's declared at the top level may refer to something
+// outside the body, and a closure run again after a goto jumps back gets a
+// fresh variable from a declaration it would share once hoisted
+func (f *flattener) checkScopes(backward bool) string {
+	for _, name := range f.outer {
+		if _, ok := f.names[name]; ok {
+			return fmt.Sprintf("%s is declared in the body and outside of it", name)
+		}
+	}
+
+	body := f.fn.Body
+	reason := ""
+	inspectAll(body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.SelectorExpr:
+			ast.Inspect(n.X, func(n ast.Node) bool {
+				reason = f.checkIdent(n, reason)
+				return reason == ""
+			})
+			return false
+		case *ast.Ident:
+			reason = f.checkIdent(n, reason)
+		}
+		return reason == ""
+	})
+	if reason != "" {
+		return reason
+	}
+
+	if backward {
+		inspectAll(body, func(n ast.Node) bool {
+			lit, ok := n.(*ast.FuncLit)
+			if !ok {
+				return reason == ""
+			}
+			ast.Inspect(lit, func(n ast.Node) bool {
+				if ident, ok := n.(*ast.Ident); ok {
+					if _, ok := f.hoisted[f.p.info.Uses[ident]]; ok {
+						reason = fmt.Sprintf("a closure captures %s and a goto jumps back", ident.Name)
+					}
+				}
+				return reason == ""
+			})
+			return false
+		})
+	}
+	return reason
+}
+
+// checkIdent reports an identifier that would refer to a hoisted name in
+// place of a declaration outside the body
+func (f *flattener) checkIdent(n ast.Node, reason string) string {
+	ident, ok := n.(*ast.Ident)
+	if !ok || reason != "" {
+		return reason
+	}
+	if _, ok := f.names[ident.Name]; !ok {
+		return ""
+	}
+	obj := f.p.info.Uses[ident]
+	if obj == nil {
+		return ""
+	}
+	if _, ok := f.hoisted[obj]; ok {
+		return ""
+	}
+	switch obj := obj.(type) {
+	case *types.Label:
+		return ""
+	case *types.Var:
+		if obj.IsField() {
+			return ""
+		}
+	}
+	if obj.Pos().IsValid() && f.fn.Body.Pos() <= obj.Pos() && obj.Pos() < f.fn.Body.End() {
+		return ""
+	}
+	return fmt.Sprintf("%s is used before it's declared in the body", ident.Name)
+}
+
+// newStates draws a distinct state for every unit
+func (f *flattener) newStates() error {
+	seen := make(map[int]struct{})
+	for i := range f.units {
+		for attempt := 0; ; attempt++ {
+			b, err := f.r.namer.Bytes(fmt.Sprintf("%s:%d:%d", f.key, i, attempt), 4)
+			if err != nil {
+				return err
+			}
+			// states fit an int on every platform
+			state := int(binary.BigEndian.Uint32(b) & 0x7fffffff)
+			if _, ok := seen[state]; ok {
+				continue
+			}
+			seen[state] = struct{}{}
+			f.states = append(f.states, state)
+			break
+		}
+	}
+	return nil
+}
+
+func (f *flattener) stateLit(state int) *ast.BasicLit {
+	return &ast.BasicLit{Kind: token.INT, Value: fmt.Sprintf("0x%08x", state)}
+}
+
+// jump moves from one unit to another
+func (f *flattener) jump(from, to int) ast.Stmt {
+	return &ast.AssignStmt{
+		Lhs: []ast.Expr{ast.NewIdent(f.state)},
+		Tok: token.XOR_ASSIGN,
+		Rhs: []ast.Expr{f.stateLit(f.states[from] ^ f.states[to])},
+	}
+}
+
+// gotoTarget finds the unit a goto to a top-level label leads to
+func (f *flattener) gotoTarget(stmt ast.Stmt) (int, bool) {
+	b, ok := stmt.(*ast.BranchStmt)
+	if !ok || b.Tok != token.GOTO {
+		return 0, false
+	}
+	to, ok := f.labels[b.Label.Name]
+	return to, ok
+}
+
+// replaceGotos turns gotos to top-level labels in the statements of a unit
+// into state changes that restart the loop
+func (f *flattener) replaceGotos(stmts []ast.Stmt, from int) {
+	replace := func(list []ast.Stmt) {
+		for i, stmt := range list {
+			if to, ok := f.gotoTarget(stmt); ok {
+				f.jumped = true
+				list[i] = &ast.BlockStmt{List: []ast.Stmt{
+					f.jump(from, to),
+					&ast.BranchStmt{Tok: token.CONTINUE, Label: ast.NewIdent(f.loop)},
+				}}
+			}
+		}
+	}
+	replace(stmts)
+	for _, stmt := range stmts {
+		inspectAll(stmt, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.FuncLit:
+				return false
+			case *ast.BlockStmt:
+				replace(n.List)
+			case *ast.CaseClause:
+				replace(n.Body)
+			case *ast.CommClause:
+				replace(n.Body)
+			case *ast.LabeledStmt:
+				list := []ast.Stmt{n.Stmt}
+				replace(list)
+				n.Stmt = list[0]
+			}
+			return true
+		})
+	}
+}
+
+// isTerminal reports whether control never leaves the end of stmt
+func (f *flattener) isTerminal(stmt ast.Stmt) bool {
+	switch s := stmt.(type) {
+	case *ast.ReturnStmt:
+		return true
+	case *ast.BranchStmt:
+		_, ok := f.gotoTarget(s)
+		return ok
+	case *ast.ExprStmt:
+		call, ok := s.X.(*ast.CallExpr)
+		if !ok {
+			return false
+		}
+		ident, ok := call.Fun.(*ast.Ident)
+		if !ok {
+			return false
+		}
+		builtin, ok := f.p.info.Uses[ident].(*types.Builtin)
+		return ok && builtin.Name() == "panic"
+	}
+	return false
+}
+
+// typeExpr names t ahead of the body of the function, or returns false if
+// there's no way to
+func (f *flattener) typeExpr(t types.Type) (ast.Expr, bool) {
+	switch t := types.Unalias(t).(type) {
+	case *types.Basic:
+		if t.Info()&types.IsUntyped != 0 {
+			return nil, false
+		}
+		if t.Kind() == types.UnsafePointer {
+			q, ok := f.qualifier(types.Unsafe)
+			if !ok {
+				return nil, false
+			}
+			return &ast.SelectorExpr{X: ast.NewIdent(q), Sel: ast.NewIdent("Pointer")}, true
+		}
+		f.outer = append(f.outer, t.Name())
+		return ast.NewIdent(t.Name()), true
+	case *types.Named:
+		return f.namedExpr(t)
+	case *types.TypeParam:
+		return ast.NewIdent(t.Obj().Name()), true
+	case *types.Pointer:
+		elem, ok := f.typeExpr(t.Elem())
+		return &ast.StarExpr{X: elem}, ok
+	case *types.Slice:
+		elem, ok := f.typeExpr(t.Elem())
+		return &ast.ArrayType{Elt: elem}, ok
+	case *types.Array:
+		elem, ok := f.typeExpr(t.Elem())
+		n := &ast.BasicLit{Kind: token.INT, Value: strconv.FormatInt(t.Len(), 10)}
+		return &ast.ArrayType{Len: n, Elt: elem}, ok
+	case *types.Map:
+		key, ok := f.typeExpr(t.Key())
+		if !ok {
+			return nil, false
+		}
+		elem, ok := f.typeExpr(t.Elem())
+		return &ast.MapType{Key: key, Value: elem}, ok
+	case *types.Chan:
+		// the arrow of a nested channel binds to the outer one
+		if _, ok := t.Elem().(*types.Chan); ok {
+			return nil, false
+		}
+		elem, ok := f.typeExpr(t.Elem())
+		dir := ast.SEND | ast.RECV
+		switch t.Dir() {
+		case types.SendOnly:
+			dir = ast.SEND
+		case types.RecvOnly:
+			dir = ast.RECV
+		}
+		return &ast.ChanType{Dir: dir, Value: elem}, ok
+	case *types.Signature:
+		return f.funcExpr(t)
+	case *types.Struct:
+		return f.structExpr(t)
+	case *types.Interface:
+		if t.NumMethods() == 0 && t.NumEmbeddeds() == 0 {
+			return &ast.InterfaceType{Methods: &ast.FieldList{}}, true
+		}
+	}
+	return nil, false
+}
+
+// namedExpr names a defined type, aliased if it's renamed
+func (f *flattener) namedExpr(t *types.Named) (ast.Expr, bool) {
+	obj := t.Obj()
+	name, ok := f.name(obj)
+	if !ok {
+		return nil, false
+	}
+
+	var expr ast.Expr
+	switch {
+	case obj.Pkg() == nil:
+		// error and comparable
+		f.outer = append(f.outer, name)
+		expr = ast.NewIdent(name)
+	case obj.Pkg() == f.p.types:
+		// local types are only in scope if they're hoisted too
+		if obj.Parent() != f.p.types.Scope() {
+			if _, ok := f.hoisted[obj]; !ok {
+				return nil, false
+			}
+		} else {
+			f.outer = append(f.outer, name)
+		}
+		expr = ast.NewIdent(name)
+	default:
+		if !obj.Exported() {
+			return nil, false
+		}
+		q, ok := f.qualifier(obj.Pkg())
+		if !ok {
+			return nil, false
+		}
+		expr = &ast.SelectorExpr{X: ast.NewIdent(q), Sel: ast.NewIdent(name)}
+	}
+
+	args := t.TypeArgs()
+	if args.Len() == 0 {
+		return expr, true
+	}
+	var indices []ast.Expr
+	for i := 0; i < args.Len(); i++ {
+		arg, ok := f.typeExpr(args.At(i))
+		if !ok {
+			return nil, false
+		}
+		indices = append(indices, arg)
+	}
+	return &ast.IndexListExpr{X: expr, Indices: indices}, true
+}
+
+// funcExpr names a function type
+func (f *flattener) funcExpr(sig *types.Signature) (ast.Expr, bool) {
+	fields := func(tuple *types.Tuple, variadic bool) (*ast.FieldList, bool) {
+		list := &ast.FieldList{}
+		for i := 0; i < tuple.Len(); i++ {
+			t := tuple.At(i).Type()
+			if variadic && i == tuple.Len()-1 {
+				elem, ok := f.typeExpr(t.(*types.Slice).Elem())
+				if !ok {
+					return nil, false
+				}
+				list.List = append(list.List, &ast.Field{Type: &ast.Ellipsis{Elt: elem}})
+				continue
+			}
+			expr, ok := f.typeExpr(t)
+			if !ok {
+				return nil, false
+			}
+			list.List = append(list.List, &ast.Field{Type: expr})
+		}
+		return list, true
+	}
+	params, ok := fields(sig.Params(), sig.Variadic())
+	if !ok {
+		return nil, false
+	}
+	results, ok := fields(sig.Results(), false)
+	if !ok {
+		return nil, false
+	}
+	return &ast.FuncType{Params: params, Results: results}, true
+}
+
+// structExpr names a struct type, its unexported fields have to belong to
+// the package for the type to be the same
+func (f *flattener) structExpr(t *types.Struct) (ast.Expr, bool) {
+	list := &ast.FieldList{}
+	for i := 0; i < t.NumFields(); i++ {
+		field := t.Field(i)
+		if !field.Exported() && field.Pkg() != f.p.types {
+			return nil, false
+		}
+		expr, ok := f.typeExpr(field.Type())
+		if !ok {
+			return nil, false
+		}
+		out := &ast.Field{Type: expr}
+		if !field.Embedded() {
+			name, ok := f.name(field)
+			if !ok {
+				return nil, false
+			}
+			out.Names = []*ast.Ident{ast.NewIdent(name)}
+		}
+		if tag := t.Tag(i); tag != "" {
+			out.Tag = &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(tag)}
+		}
+		list.List = append(list.List, out)
+	}
+	return &ast.StructType{Fields: list}, true
+}
+
+// name is the name obj is renamed to. packages renamed later give their
+// identifiers the same alias
+func (f *flattener) name(obj types.Object) (string, bool) {
+	if !f.r.options.RenameIdentifiers {
+		return obj.Name(), true
+	}
+	key, ok := f.r.identifierKey(obj)
+	if !ok {
+		return obj.Name(), true
+	}
+	alias, err := f.r.aliasIdent(key, obj.Exported())
+	return alias, err == nil
+}
+
+// qualifier is the name the file imports pkg under
+func (f *flattener) qualifier(pkg *types.Package) (string, bool) {
+	for _, imp := range f.file.Imports {
+		var obj types.Object
+		if imp.Name != nil {
+			obj = f.p.info.Defs[imp.Name]
+		} else {
+			obj = f.p.info.Implicits[imp]
+		}
+		name, ok := obj.(*types.PkgName)
+		if !ok || name.Imported() != pkg || name.Name() == "_" || name.Name() == "." {
+			continue
+		}
+		f.outer = append(f.outer, name.Name())
+		return name.Name(), true
+	}
+	return "", false
+}
+
+// varDecl declares a hoisted variable
+func varDecl(name string, t ast.Expr) ast.Stmt {
+	return &ast.DeclStmt{Decl: &ast.GenDecl{
+		Tok:   token.VAR,
+		Specs: []ast.Spec{&ast.ValueSpec{Names: []*ast.Ident{ast.NewIdent(name)}, Type: t}},
+	}}
+}
+
+// branchesTo reports whether a break or continue in stmt names the label
+func branchesTo(stmt ast.Stmt, label string) bool {
+	found := false
+	inspectBody(stmt, func(n ast.Node) {
+		if b, ok := n.(*ast.BranchStmt); ok && b.Tok != token.GOTO && b.Label != nil && b.Label.Name == label {
+			found = true
+		}
+	})
+	return found
+}
+
+// inspectBody calls fn with every node of n outside of function literals,
+// whose labels and gotos are their own
+func inspectBody(n ast.Node, fn func(ast.Node)) {
+	ast.Inspect(n, func(n ast.Node) bool {
+		if _, ok := n.(*ast.FuncLit); ok {
+			return false
+		}
+		if n != nil {
+			fn(n)
+		}
+		return true
+	})
+}
+
+// inspectAll is ast.Inspect skipping the nil calls that end each node
+func inspectAll(n ast.Node, fn func(ast.Node) bool) {
+	ast.Inspect(n, func(n ast.Node) bool {
+		return n != nil && fn(n)
+	})
+}
@@ -0,0 +1,174 @@
+package obfuscator
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const flattenSource = `package main
+
+import "fmt"
+
+func countdown(n int) (out []int) {
+loop:
+	if n == 0 {
+		return
+	}
+	out = append(out, n)
+	n--
+	goto loop
+}
+
+func skip(x int) string {
+	s := "start"
+	if x > 0 {
+		goto done
+	}
+	s += "-negative"
+done:
+	s += "-done"
+	return s
+}
+
+func deferred() (n int) {
+	defer func() { n *= 2 }()
+	n = 3
+	n++
+	return n
+}
+
+func recovered() (msg string) {
+	defer func() {
+		if r := recover(); r != nil {
+			msg = fmt.Sprint("recovered ", r)
+		}
+	}()
+	msg = "none"
+	var m map[string]int
+	m["x"] = 1
+	return
+}
+
+func named(a, b int) (sum, diff int) {
+	sum = a + b
+	if a < b {
+		diff = b - a
+		return
+	}
+	diff = a - b
+	return
+}
+
+func captured() int {
+	i := 0
+	var fs []func() int
+again:
+	j := i
+	fs = append(fs, func() int { return j })
+	i++
+	if i < 3 {
+		goto again
+	}
+	return fs[0]() + fs[1]() + fs[2]()
+}
+
+func main() {
+	sum, diff := named(2, 5)
+	fmt.Println(countdown(3), skip(1), skip(-1), deferred(), recovered(), sum, diff, captured())
+}
+`
+
+func TestFlatten(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"go.mod":      "module example.com/flat\n\ngo 1.16\n",
+		"app/main.go": flattenSource,
+	})
+
+	target := t.TempDir()
+	out := runTarget(t, Options{
+		SrcPath:    filepath.Join(root, "app"),
+		RootPath:   root,
+		TargetPath: target,
+		Flatten:    []string{`re:example\.com/flat/app\.(countdown|skip|deferred|recovered|named|captured)`},
+	})
+	want := "[3 2 1] start-done start-negative-done 8 recovered assignment to entry in nil map 7 3 3\n"
+	if out != want {
+		t.Errorf("target printed %q, want %q", out, want)
+	}
+
+	// a goto jumping back would run the closure again over the one
+	// hoisted j, so captured is left as it is
+	tests := []struct {
+		name      string
+		flattened bool
+	}{
+		{"countdown", true},
+		{"skip", true},
+		{"deferred", true},
+		{"recovered", true},
+		{"named", true},
+		{"captured", false},
+		{"main", false},
+	}
+	decls := make(map[string]*ast.FuncDecl)
+	for _, name := range targetFiles(t, target) {
+		if !strings.HasSuffix(name, ".go") {
+			continue
+		}
+		file, err := parser.ParseFile(token.NewFileSet(), filepath.Join(target, filepath.FromSlash(name)), nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, decl := range file.Decls {
+			if fd, ok := decl.(*ast.FuncDecl); ok {
+				decls[fd.Name.Name] = fd
+			}
+		}
+	}
+	for _, test := range tests {
+		fd, ok := decls[test.name]
+		if !ok {
+			t.Errorf("%s not found in the target", test.name)
+			continue
+		}
+		if got := isFlattened(fd); got != test.flattened {
+			t.Errorf("%s flattened = %v, want %v", test.name, got, test.flattened)
+		}
+	}
+}
+
+// isFlattened reports whether the body of fd ends in a dispatch loop
+func isFlattened(fd *ast.FuncDecl) bool {
+	stmt := fd.Body.List[len(fd.Body.List)-1]
+	if labeled, ok := stmt.(*ast.LabeledStmt); ok {
+		stmt = labeled.Stmt
+	}
+	loop, ok := stmt.(*ast.ForStmt)
+	if !ok || loop.Cond != nil || len(loop.Body.List) != 1 {
+		return false
+	}
+	_, ok = loop.Body.List[0].(*ast.SwitchStmt)
+	return ok
+}
+
+func TestFlattenDirectiveFails(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"go.mod":      "module example.com/flat\n\ngo 1.16\n",
+		"app/main.go": strings.Replace(flattenSource, "func captured", "//gobf:flatten\nfunc captured", 1),
+	})
+
+	_, err := Rewrite(Options{
+		SrcPath:    filepath.Join(root, "app"),
+		RootPath:   root,
+		TargetPath: t.TempDir(),
+	})
+	if err == nil || !strings.Contains(err.Error(), ErrFlatten.Error()) {
+		t.Errorf("got %v, want %v", err, ErrFlatten)
+	}
+}
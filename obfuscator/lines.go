@@ -0,0 +1,28 @@
+package obfuscator
+
+import (
+	"bytes"
+	"go/ast"
+	"go/printer"
+	"go/token"
+	"io"
+)
+
+// formatWithLines prints file with //line directives that point every line
+// back at its line in the original source. The directives name the file by
+// its alias so the original location doesn't leak into the binary, the
+// mapping recovers it
+func formatWithLines(w io.Writer, fset *token.FileSet, file *ast.File, src, alias string) error {
+	var buf bytes.Buffer
+	config := printer.Config{
+		Mode:     printer.UseSpaces | printer.TabIndent | printer.SourcePos,
+		Tabwidth: 8,
+	}
+	if err := config.Fprint(&buf, fset, file); err != nil {
+		return err
+	}
+
+	out := bytes.Replace(buf.Bytes(), []byte("line "+src+":"), []byte("line "+alias+":"), -1)
+	_, err := w.Write(out)
+	return err
+}
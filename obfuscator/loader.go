@@ -0,0 +1,303 @@
+package obfuscator
+
+import (
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/types"
+	"io/ioutil"
+)
+
+// loadedPackage is a parsed and optionally type-checked package as it builds
+// for one platform. Files are shared by every platform they build for
+type loadedPackage struct {
+	build *build.Package
+	paths []string
+	files []*ast.File
+	types *types.Package
+	info  *types.Info
+
+	// names used by reflection in the package that must not be renamed,
+	// shared by every platform
+	keep map[string]struct{}
+
+	// opaque packages are referred to by name from sources we can't rewrite
+	// so none of their identifiers are renamed
+	opaque bool
+
+	// test is the package recompiled with its internal test files and xtest
+	// the external test package, either may be nil
+	test  *loadedPackage
+	xtest *loadedPackage
+}
+
+// loadPackage parses the go files of pkg for the platform. the result is
+// cached so every package is loaded once per platform
+func (pl *platform) loadPackage(pkg *build.Package) (*loadedPackage, error) {
+	if p, ok := pl.loaded[pkg.Dir]; ok {
+		return p, nil
+	}
+	r := pl.rewriter
+
+	paths, files, err := r.parseGoFiles(pkg)
+	if err != nil {
+		return nil, err
+	}
+	opaque, err := isOpaque(pkg)
+	if err != nil {
+		return nil, r.fail(ParseError, pkg.Dir, err)
+	}
+
+	keep, ok := r.keepNames[pkg.Dir]
+	if !ok {
+		keep = make(map[string]struct{})
+		r.keepNames[pkg.Dir] = keep
+	}
+
+	p := &loadedPackage{
+		build:  pkg,
+		paths:  paths,
+		files:  files,
+		keep:   keep,
+		opaque: opaque,
+	}
+	pl.loaded[pkg.Dir] = p
+	return p, nil
+}
+
+// parseGoFiles parses the go and cgo files of pkg
+func (r *rewriter) parseGoFiles(pkg *build.Package) ([]string, []*ast.File, error) {
+	var paths []string
+	paths = append(paths, pkg.GoFiles...)
+	prefixDirectory(pkg.Dir, paths)
+	files, err := r.parseFiles(paths, parseMode)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var cgoPaths []string
+	cgoPaths = append(cgoPaths, pkg.CgoFiles...)
+	prefixDirectory(pkg.Dir, cgoPaths)
+	cgoFiles, err := r.parseFiles(cgoPaths, parseMode)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return append(paths, cgoPaths...), append(files, cgoFiles...), nil
+}
+
+// parseTestFiles parses the internal and external test files of pkg
+func (r *rewriter) parseTestFiles(pkg *build.Package) ([]string, []*ast.File, error) {
+	var paths []string
+	paths = append(paths, pkg.TestGoFiles...)
+	paths = append(paths, pkg.XTestGoFiles...)
+	prefixDirectory(pkg.Dir, paths)
+	files, err := r.parseFiles(paths, parseMode)
+	if err != nil {
+		return nil, nil, err
+	}
+	return paths, files, nil
+}
+
+// parseFiles parses each file once, platforms that build the same file
+// share its syntax tree so renaming it for one renames it for all
+func (r *rewriter) parseFiles(paths []string, mode parser.Mode) ([]*ast.File, error) {
+	var files []*ast.File
+	for _, path := range paths {
+		if file, ok := r.parsed[path]; ok {
+			files = append(files, file)
+			continue
+		}
+		code, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, r.fail(ParseError, path, err)
+		}
+		file, err := parser.ParseFile(r.fset, path, code, mode)
+		if err != nil {
+			return nil, r.fail(ParseError, path, err)
+		}
+		r.parsed[path] = file
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// checkPackage type-checks pkg for the platform, loading and checking its
+// imports on demand. failures are cached too so a package that doesn't
+// check fails the same way for every importer
+func (pl *platform) checkPackage(pkg *build.Package) (*loadedPackage, error) {
+	if err, ok := pl.failed[pkg.Dir]; ok {
+		return nil, err
+	}
+	r := pl.rewriter
+	r.chain = append(r.chain, pkg.ImportPath)
+	defer func() { r.chain = r.chain[:len(r.chain)-1] }()
+
+	p, err := pl.loadPackage(pkg)
+	if err != nil {
+		pl.failed[pkg.Dir] = err
+		return nil, err
+	}
+	if p.types != nil {
+		return p, nil
+	}
+
+	if err := pl.check(p, pkg.ImportPath, pl, nil); err != nil {
+		pl.failed[pkg.Dir] = err
+		return nil, err
+	}
+	pl.checked[p.types.Path()] = p
+	r.collectKeep(p, p)
+
+	return p, nil
+}
+
+// checkTests type-checks the internal test files of p along with the package
+// and its external test package against that
+func (pl *platform) checkTests(p *loadedPackage) error {
+	if p.test != nil || p.xtest != nil {
+		return nil
+	}
+	r := pl.rewriter
+	pkg := p.build
+
+	if len(pkg.TestGoFiles) > 0 {
+		var paths []string
+		paths = append(paths, pkg.TestGoFiles...)
+		prefixDirectory(pkg.Dir, paths)
+		files, err := r.parseFiles(paths, parseMode)
+		if err != nil {
+			return err
+		}
+
+		p.test = &loadedPackage{
+			build: pkg,
+			paths: append(append([]string{}, p.paths...), paths...),
+			files: append(append([]*ast.File{}, p.files...), files...),
+			keep:  p.keep,
+		}
+		if err := pl.check(p.test, pkg.ImportPath, pl, nil); err != nil {
+			return err
+		}
+		r.collectKeep(p, p.test)
+	}
+
+	if len(pkg.XTestGoFiles) > 0 {
+		var paths []string
+		paths = append(paths, pkg.XTestGoFiles...)
+		prefixDirectory(pkg.Dir, paths)
+		files, err := r.parseFiles(paths, parseMode)
+		if err != nil {
+			return err
+		}
+
+		p.xtest = &loadedPackage{
+			build: pkg,
+			paths: paths,
+			files: files,
+			keep:  p.keep,
+		}
+		under := p
+		if p.test != nil {
+			under = p.test
+		}
+		imp := &testImporter{
+			platform: pl,
+			path:     pkg.ImportPath,
+			pkg:      under.types,
+		}
+		// go test recompiles the xtest's other imports against the test
+		// variant of the package, which we don't. the mismatched types only
+		// produce errors where both meet so they're ignored, every identifier
+		// is still resolved
+		if err := pl.check(p.xtest, pkg.ImportPath+"_test", imp, func(error) {}); err != nil {
+			return err
+		}
+		r.collectKeep(p, p.xtest)
+	}
+
+	return nil
+}
+
+// check runs the type checker over the files of p
+func (pl *platform) check(p *loadedPackage, path string, imp types.Importer, errorHandler func(error)) error {
+	p.info = &types.Info{
+		Types:      make(map[ast.Expr]types.TypeAndValue),
+		Defs:       make(map[*ast.Ident]types.Object),
+		Uses:       make(map[*ast.Ident]types.Object),
+		Implicits:  make(map[ast.Node]types.Object),
+		Selections: make(map[*ast.SelectorExpr]*types.Selection),
+	}
+	conf := types.Config{
+		Importer:    imp,
+		FakeImportC: true,
+		Error:       errorHandler,
+		Sizes:       pl.sizes,
+	}
+	// imports checked meanwhile keep their own failures
+	outer := pl.importErr
+	pl.importErr = nil
+	var err error
+	p.types, err = conf.Check(path, pl.rewriter.fset, p.files, p.info)
+	importErr := pl.importErr
+	pl.importErr = outer
+	if err == nil || errorHandler != nil {
+		return nil
+	}
+	// a failed import is reported where it failed rather than as the
+	// importing package's type error
+	if importErr != nil {
+		return importErr
+	}
+	return pl.rewriter.fail(CheckError, p.build.Dir, err)
+}
+
+// collectKeep records names that must not be renamed in the files of src
+// against the package p. exported names are shared by every package in the
+// target so names that must be kept anywhere are kept everywhere
+func (r *rewriter) collectKeep(p, src *loadedPackage) {
+	r.collectInternalTypes(src)
+	r.collectDirectives(src)
+	r.collectFlattenTargets(src)
+	for name := range reflectedNames(src) {
+		p.keep[name] = struct{}{}
+	}
+	for name := range r.taggedFieldNames(src) {
+		p.keep[name] = struct{}{}
+	}
+	for name := range cgoExportNames(src) {
+		p.keep[name] = struct{}{}
+	}
+	for name := range linknameNames(src) {
+		p.keep[name] = struct{}{}
+	}
+	for name := range r.keptIdentifiers(src) {
+		p.keep[name] = struct{}{}
+	}
+	r.collectReflected(src)
+	for name := range p.keep {
+		if ast.IsExported(name) {
+			r.keep[name] = struct{}{}
+		}
+	}
+}
+
+// testImporter resolves the package under test to its test variant
+type testImporter struct {
+	platform *platform
+	path     string
+	pkg      *types.Package
+}
+
+// Import implements types.Importer
+func (i *testImporter) Import(path string) (*types.Package, error) {
+	return i.ImportFrom(path, i.platform.context.Dir, 0)
+}
+
+// ImportFrom implements types.ImporterFrom
+func (i *testImporter) ImportFrom(path, dir string, mode types.ImportMode) (*types.Package, error) {
+	if path == i.path {
+		return i.pkg, nil
+	}
+	return i.platform.ImportFrom(path, dir, mode)
+}
@@ -0,0 +1,126 @@
+package obfuscator
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+)
+
+// errors
+var (
+	ErrMappingVersion = errors.New("unsupported mapping file version")
+)
+
+const (
+	// MappingVersion is the version of the mapping file format written by
+	// this package
+	MappingVersion = 1
+)
+
+// Mapping records the alias given to every original name in an obfuscated
+// build. Import paths map to the path the aliased package is imported by in
+// the target tree, files are keyed by their package import path. Line
+// numbers in the binary are the original ones only if LineDirectives is set
+type Mapping struct {
+	Version        int               `json:"version"`
+	LineDirectives bool              `json:"lineDirectives,omitempty"`
+	Modules        map[string]string `json:"modules,omitempty"`
+	Directories    map[string]string `json:"directories"`
+	ImportPaths    map[string]string `json:"importPaths"`
+	Files          map[string]string `json:"files"`
+	Identifiers    map[string]string `json:"identifiers"`
+	Tags           map[string]string `json:"tags,omitempty"`
+}
+
+// NewMapping ...
+func NewMapping() *Mapping {
+	return &Mapping{
+		Version:     MappingVersion,
+		Modules:     make(map[string]string),
+		Directories: make(map[string]string),
+		ImportPaths: make(map[string]string),
+		Files:       make(map[string]string),
+		Identifiers: make(map[string]string),
+		Tags:        make(map[string]string),
+	}
+}
+
+// LoadMapping reads a mapping file written by a previous build
+func LoadMapping(filename string) (*Mapping, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	m := NewMapping()
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	if m.Version != MappingVersion {
+		return nil, ErrMappingVersion
+	}
+
+	return m, nil
+}
+
+// Save writes the mapping as indented JSON
+func (m *Mapping) Save(filename string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, append(data, '\n'), 0644)
+}
+
+// Apply assigns every alias in the mapping to n so names seen by a previous
+// build keep their aliases
+func (m *Mapping) Apply(n *Namer) {
+	for name, alias := range m.Modules {
+		// modules without a version are recorded by their path alone
+		if !strings.Contains(name, "@") {
+			name += "@"
+		}
+		n.Assign(alias, name)
+	}
+	for name, alias := range m.Directories {
+		n.Assign(alias, name)
+	}
+	for name, importPath := range m.ImportPaths {
+		n.Assign(path.Base(importPath), name)
+	}
+	for name, alias := range m.Files {
+		alias = strings.TrimSuffix(alias, ".go")
+		alias = strings.TrimSuffix(alias, "_test")
+		alias = strings.TrimSuffix(alias, platformSuffix(alias+".go"))
+		n.Assign(alias, name)
+	}
+	for name, alias := range m.Identifiers {
+		n.Assign(alias, name)
+	}
+	for name, alias := range m.Tags {
+		n.Assign(alias, "tag:"+name)
+	}
+}
+
+// OriginalNames lists the original module paths, import paths and
+// directories in the mapping, none of which should be found in the binary
+func (m *Mapping) OriginalNames() []string {
+	var names []string
+	for key := range m.Modules {
+		if i := strings.LastIndex(key, "@"); i != -1 {
+			key = key[:i]
+		}
+		names = append(names, key)
+	}
+	for name := range m.ImportPaths {
+		names = append(names, name)
+	}
+	for name := range m.Directories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,125 @@
+package obfuscator
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testMapping is a mapping as a build of a small module writes it
+func testMapping() *Mapping {
+	m := NewMapping()
+	m.LineDirectives = true
+	m.Modules["example.com/proj"] = "wQkd"
+	m.Modules["example.com/dep@v1.2.0"] = "zzPx"
+	m.Directories["/src/proj"] = "hQbn"
+	m.ImportPaths["example.com/proj/users"] = "wQkd/aKxe"
+	m.ImportPaths["example.com/proj/vendor/example.com/dep"] = "wQkd/vendor/zzPx"
+	m.ImportPaths["example.com/dep"] = "wQkd/vendor/zzPx"
+	m.Files["example.com/proj/users/users.go"] = "mEoq.go"
+	m.Files["example.com/proj/users/users_linux.go"] = "pTza_linux.go"
+	m.Files["example.com/proj/users/users_test.go"] = "yRnb_test.go"
+	m.Identifiers["example.com/proj/users.Find"] = "Jdke"
+	m.Identifiers["example.com/proj/users.field:name"] = "qwod"
+	m.Identifiers["method:Save"] = "Xlpa"
+	m.Tags["email"] = "yeTr"
+	return m
+}
+
+func TestMappingRoundTrip(t *testing.T) {
+	m := testMapping()
+	filename := filepath.Join(t.TempDir(), "mapping.json")
+	if err := m.Save(filename); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadMapping(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, m) {
+		t.Errorf("loaded %+v, want %+v", loaded, m)
+	}
+
+	if err := ioutil.WriteFile(filename, []byte(`{"version": 2}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadMapping(filename); err != ErrMappingVersion {
+		t.Errorf("loading version 2: got %v, want %v", err, ErrMappingVersion)
+	}
+}
+
+func TestMappingApply(t *testing.T) {
+	n := NewNamer(4)
+	testMapping().Apply(n)
+	tests := []struct {
+		name  string
+		alias string
+	}{
+		{"example.com/proj@", "wQkd"},
+		{"example.com/dep@v1.2.0", "zzPx"},
+		{"/src/proj", "hQbn"},
+		{"example.com/proj/users", "aKxe"},
+		{"example.com/dep", "zzPx"},
+		{"example.com/proj/users/users.go", "mEoq"},
+		{"example.com/proj/users/users_linux.go", "pTza"},
+		{"example.com/proj/users/users_test.go", "yRnb"},
+		{"example.com/proj/users.Find", "Jdke"},
+		{"method:Save", "Xlpa"},
+		{"tag:email", "yeTr"},
+	}
+	for _, test := range tests {
+		if alias, _ := n.Lookup(test.name); alias != test.alias {
+			t.Errorf("%s aliased %q, want %q", test.name, alias, test.alias)
+		}
+	}
+
+	// aliases from the mapping aren't handed out again
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		alias, err := n.Alias(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, test := range tests {
+			if alias == test.alias {
+				t.Errorf("%s aliased %q, already %s's", name, alias, test.name)
+			}
+		}
+	}
+}
+
+func TestMappingOriginalNames(t *testing.T) {
+	want := []string{
+		"/src/proj",
+		"example.com/dep",
+		"example.com/dep",
+		"example.com/proj",
+		"example.com/proj/users",
+		"example.com/proj/vendor/example.com/dep",
+	}
+	if got := testMapping().OriginalNames(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestMappingLineDirectives(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"go.mod":          "module example.com/lines\n\ngo 1.16\n",
+		"cmd/app/main.go": "package main\n\nfunc main() {}\n",
+	})
+	for _, lines := range []bool{false, true} {
+		plan, err := PlanRewrite(Options{
+			SrcPath:        filepath.Join(root, "cmd", "app"),
+			RootPath:       root,
+			TargetPath:     t.TempDir(),
+			LineDirectives: lines,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plan.Mapping.LineDirectives != lines {
+			t.Errorf("line directives %v recorded as %v", lines, plan.Mapping.LineDirectives)
+		}
+	}
+}
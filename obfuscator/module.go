@@ -0,0 +1,224 @@
+package obfuscator
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// errors
+var (
+	ErrNoModule = errors.New("package does not belong to any module in the build graph")
+)
+
+const (
+	goModFile = "go.mod"
+)
+
+// Module is an entry in the source project's module graph as reported by
+// `go list -m -json all`
+type Module struct {
+	Path      string
+	Version   string
+	Main      bool
+	Dir       string
+	GoMod     string
+	GoVersion string
+}
+
+// UsesModules reports whether the project at options.RootPath will be
+// resolved through the module graph rather than GOPATH
+func UsesModules(options Options) bool {
+	if os.Getenv("GO111MODULE") == "off" {
+		return false
+	}
+	_, ok := findModuleRoot(options.RootPath)
+	return ok
+}
+
+// findModuleRoot walks up from dir looking for a go.mod file
+func findModuleRoot(dir string) (string, bool) {
+	dir = filepath.Clean(dir)
+	for {
+		if fi, err := os.Stat(filepath.Join(dir, goModFile)); err == nil && !fi.IsDir() {
+			return dir, true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// loadModules reads the module graph of the module rooted at dir
+func loadModules(dir string) ([]*Module, error) {
+	cmd := exec.Command("go", "list", "-m", "-json", "all")
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list -m: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var modules []*Module
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for {
+		m := &Module{}
+		if err := dec.Decode(m); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		modules = append(modules, m)
+	}
+
+	// longest directories first so nested modules win over their parents
+	sort.SliceStable(modules, func(i, j int) bool {
+		return len(modules[i].Dir) > len(modules[j].Dir)
+	})
+
+	return modules, nil
+}
+
+// moduleGraph maps packages of the source project onto modules and tracks
+// which modules have been aliased into the target tree
+type moduleGraph struct {
+	main    *Module
+	modules []*Module
+	used    map[*Module]struct{}
+}
+
+func newModuleGraph(root string) (*moduleGraph, error) {
+	dir, ok := findModuleRoot(root)
+	if !ok {
+		return nil, ErrNoModule
+	}
+
+	modules, err := loadModules(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &moduleGraph{
+		modules: modules,
+		used:    make(map[*Module]struct{}),
+	}
+	for _, m := range modules {
+		if m.Main {
+			g.main = m
+		}
+	}
+	if g.main == nil {
+		return nil, ErrNoModule
+	}
+
+	return g, nil
+}
+
+// Lookup finds the module containing dir
+func (g *moduleGraph) Lookup(dir string) (*Module, error) {
+	for _, m := range g.modules {
+		if m.Dir == "" {
+			continue
+		}
+		if dir == m.Dir || strings.HasPrefix(dir, m.Dir+string(filepath.Separator)) {
+			g.used[m] = struct{}{}
+			return m, nil
+		}
+	}
+	return nil, ErrNoModule
+}
+
+// ImportPath derives the import path of the package in dir from its module
+func (g *moduleGraph) ImportPath(dir string) (string, error) {
+	m, err := g.Lookup(dir)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(m.Dir, dir)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(m.Path, filepath.ToSlash(rel)), nil
+}
+
+// Dependencies returns the non-main modules that packages were resolved from
+func (g *moduleGraph) Dependencies() []*Module {
+	var deps []*Module
+	for _, m := range g.modules {
+		if _, ok := g.used[m]; ok && !m.Main {
+			deps = append(deps, m)
+		}
+	}
+	sort.Slice(deps, func(i, j int) bool {
+		return deps[i].Path < deps[j].Path
+	})
+	return deps
+}
+
+// moduleKey is the name aliased for a module. import paths can't contain @
+// so this never collides with the module's root package
+func moduleKey(m *Module) string {
+	return m.Path + "@" + m.Version
+}
+
+// moduleName is the name a module is recorded under in the mapping, its path
+// alone when it has no version like the main module
+func moduleName(m *Module) string {
+	if m.Version == "" {
+		return m.Path
+	}
+	return moduleKey(m)
+}
+
+// goVersion picks the go directive for a synthesized go.mod
+func goVersion(m *Module) string {
+	if m.GoVersion == "" {
+		return "1.11"
+	}
+	return m.GoVersion
+}
+
+// writeGoMod writes a go.mod declaring module path to dir
+func writeGoMod(dir, path, version string, requires []string, replaces map[string]string) error {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "module %s\n\ngo %s\n", path, version)
+
+	if len(requires) > 0 {
+		b.WriteString("\nrequire (\n")
+		for _, req := range requires {
+			fmt.Fprintf(&b, "\t%s v0.0.0\n", req)
+		}
+		b.WriteString(")\n")
+	}
+
+	if len(replaces) > 0 {
+		var olds []string
+		for old := range replaces {
+			olds = append(olds, old)
+		}
+		sort.Strings(olds)
+
+		b.WriteString("\nreplace (\n")
+		for _, old := range olds {
+			fmt.Fprintf(&b, "\t%s => %s\n", old, replaces[old])
+		}
+		b.WriteString(")\n")
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, goModFile), b.Bytes(), 0644)
+}
@@ -1,10 +1,18 @@
 package obfuscator
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/binary"
 	"errors"
+	"fmt"
+	"go/token"
+	"go/types"
 	"regexp"
+	"strings"
+	"sync"
 )
 
 // errors
@@ -16,35 +24,81 @@ var (
 	unsafeChars = regexp.MustCompile("[^a-zA-Z]")
 )
 
-// Namer ...
+// Namer hands out aliases, it's safe for concurrent use. Aliases depend on
+// the order names are first seen in so rewrites alias in a fixed order
 type Namer struct {
-	length int
-	names  map[string]string
-	used   map[string]struct{}
+	mu sync.Mutex
+
+	length   int
+	seed     []byte
+	names    map[string]string
+	used     map[string]struct{}
+	reserved map[string]struct{}
 }
 
 // NewNamer ...
 func NewNamer(length int) *Namer {
 	return &Namer{
-		length: length,
-		names:  make(map[string]string),
-		used:   make(map[string]struct{}),
+		length:   length,
+		names:    make(map[string]string),
+		used:     make(map[string]struct{}),
+		reserved: make(map[string]struct{}),
 	}
 }
 
-func (n *Namer) makeUnique() (string, error) {
-	data := make([]byte, n.length*2)
-	if _, err := rand.Read(data); err != nil {
-		return "", err
+// NewSeededNamer creates a Namer whose aliases are derived from seed and the
+// name being aliased, so the same seed and names always produce the same
+// aliases
+func NewSeededNamer(length int, seed string) *Namer {
+	n := NewNamer(length)
+	n.seed = []byte(seed)
+	return n
+}
+
+// entropy returns size bytes of randomness for name. without a seed it reads
+// from crypto/rand, with one it expands an HMAC of name keyed by the seed
+func (n *Namer) entropy(name string, size int) ([]byte, error) {
+	data := make([]byte, size)
+	if n.seed == nil {
+		_, err := rand.Read(data)
+		return data, err
 	}
-	alias := base64.URLEncoding.EncodeToString(data)
-	alias = unsafeChars.ReplaceAllString(alias, "")
 
-	return alias[0:n.length], nil
+	var counter [8]byte
+	for i := 0; i < size; {
+		binary.BigEndian.PutUint64(counter[:], uint64(i))
+		mac := hmac.New(sha256.New, n.seed)
+		mac.Write(counter[:])
+		mac.Write([]byte(name))
+		i += copy(data[i:], mac.Sum(nil))
+	}
+	return data, nil
+}
+
+func (n *Namer) makeUnique(name string, attempt int) (string, error) {
+	for {
+		data, err := n.entropy(fmt.Sprintf("%s\x00%d", name, attempt), n.length*2)
+		if err != nil {
+			return "", err
+		}
+		alias := base64.URLEncoding.EncodeToString(data)
+		alias = unsafeChars.ReplaceAllString(alias, "")
+
+		if len(alias) >= n.length {
+			return alias[0:n.length], nil
+		}
+		attempt++
+	}
 }
 
 // Assign values to aliases manually
 func (n *Namer) Assign(alias string, names ...string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.assign(alias, names...)
+}
+
+func (n *Namer) assign(alias string, names ...string) {
 	n.used[alias] = struct{}{}
 	for _, name := range names {
 		n.names[name] = alias
@@ -54,6 +108,12 @@ func (n *Namer) Assign(alias string, names ...string) {
 // AliasAll assign the same alias to all the names given. If we've already
 // aliased one of the names use the existing alias...
 func (n *Namer) AliasAll(names []string) (string, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.aliasAll(names)
+}
+
+func (n *Namer) aliasAll(names []string) (string, error) {
 	// avoid aliasing aliases... it's possible, however unlikely, for this to
 	// fail if we generate an alias that collides with a name
 	var aliased []string
@@ -71,28 +131,28 @@ func (n *Namer) AliasAll(names []string) (string, error) {
 	}
 
 	if len(aliased) == 1 {
-		n.Assign(aliased[0], names...)
+		n.assign(aliased[0], names...)
 		return aliased[0], nil
 	}
 
 	// copy existing alias
 	for _, name := range names {
 		if alias, ok := n.names[name]; ok {
-			n.Assign(alias, names...)
+			n.assign(alias, names...)
 			return alias, nil
 		}
 	}
 
 	// generate new alias
-	for {
-		alias, err := n.makeUnique()
+	for attempt := 0; ; attempt++ {
+		alias, err := n.makeUnique(names[0], attempt)
 		if err != nil {
 			return "", err
 		}
 
 		if _, ok := n.used[alias]; !ok {
 			n.used[alias] = struct{}{}
-			n.Assign(alias, names...)
+			n.assign(alias, names...)
 			return alias, nil
 		}
 	}
@@ -102,3 +162,67 @@ func (n *Namer) AliasAll(names []string) (string, error) {
 func (n *Namer) Alias(name string) (string, error) {
 	return n.AliasAll([]string{name})
 }
+
+// Lookup returns the alias assigned to name without creating one
+func (n *Namer) Lookup(name string) (string, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	alias, ok := n.names[name]
+	return alias, ok
+}
+
+// Reserve prevents identifiers that already exist in the source from being
+// handed out as aliases
+func (n *Namer) Reserve(names ...string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, name := range names {
+		n.reserved[name] = struct{}{}
+	}
+}
+
+// AliasIdent assigns an alias to a qualified identifier key. The alias is a
+// valid Go identifier that keeps the exported-ness of the original
+func (n *Namer) AliasIdent(key string, exported bool) (string, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if alias, ok := n.names[key]; ok {
+		return alias, nil
+	}
+
+	for attempt := 0; ; attempt++ {
+		alias, err := n.makeUnique(key, attempt)
+		if err != nil {
+			return "", err
+		}
+		if exported {
+			alias = strings.ToUpper(alias[:1]) + alias[1:]
+		} else {
+			alias = strings.ToLower(alias[:1]) + alias[1:]
+		}
+
+		if !n.isAvailableIdent(alias) {
+			continue
+		}
+
+		n.used[alias] = struct{}{}
+		n.assign(alias, key)
+		return alias, nil
+	}
+}
+
+// Bytes returns size random bytes for name, derived from the seed if the
+// Namer has one
+func (n *Namer) Bytes(name string, size int) ([]byte, error) {
+	return n.entropy(name, size)
+}
+
+func (n *Namer) isAvailableIdent(alias string) bool {
+	if _, ok := n.used[alias]; ok {
+		return false
+	}
+	if _, ok := n.reserved[alias]; ok {
+		return false
+	}
+	return !token.Lookup(alias).IsKeyword() && types.Universe.Lookup(alias) == nil
+}
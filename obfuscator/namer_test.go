@@ -0,0 +1,120 @@
+package obfuscator
+
+import (
+	"bytes"
+	"go/token"
+	"testing"
+)
+
+func TestSeededNamer(t *testing.T) {
+	names := []string{"github.com/a/b", "github.com/a/c", "main.go", "x"}
+	tests := []struct {
+		seedA, seedB string
+		reverse      bool
+		same         bool
+	}{
+		{"seed", "seed", false, true},
+		{"seed", "seed", true, true},
+		{"seed", "other", false, false},
+		{"", "seed", false, false},
+	}
+	for _, test := range tests {
+		a, b := NewSeededNamer(8, test.seedA), NewSeededNamer(8, test.seedB)
+		aliases := make(map[string]string)
+		for _, name := range names {
+			alias, err := a.Alias(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(alias) != 8 {
+				t.Errorf("alias %q of %s isn't 8 long", alias, name)
+			}
+			aliases[name] = alias
+		}
+		order := append([]string{}, names...)
+		if test.reverse {
+			for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+				order[i], order[j] = order[j], order[i]
+			}
+		}
+		for _, name := range order {
+			alias, err := b.Alias(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if same := alias == aliases[name]; same != test.same {
+				t.Errorf("seeds %q and %q: %s aliased %q and %q", test.seedA, test.seedB, name, aliases[name], alias)
+			}
+		}
+
+		x, _ := a.Bytes("key", 32)
+		y, _ := b.Bytes("key", 32)
+		if same := bytes.Equal(x, y); same != test.same {
+			t.Errorf("seeds %q and %q: bytes equal = %v", test.seedA, test.seedB, same)
+		}
+	}
+}
+
+func TestNamerAliasIdent(t *testing.T) {
+	tests := []struct {
+		key      string
+		exported bool
+	}{
+		{"example.com/a.Handler", true},
+		{"example.com/a.handler", false},
+		{"field:Name", true},
+		{"example.com/a.method:run", false},
+	}
+	for _, test := range tests {
+		// the alias a fresh namer hands out is reserved so another has to
+		// pick a different one
+		first, err := NewSeededNamer(6, "seed").AliasIdent(test.key, test.exported)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := NewSeededNamer(6, "seed")
+		n.Reserve(first)
+		alias, err := n.AliasIdent(test.key, test.exported)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if alias == first {
+			t.Errorf("%s aliased to reserved %q", test.key, alias)
+		}
+		if !token.IsIdentifier(alias) || token.IsExported(alias) != test.exported {
+			t.Errorf("%s aliased to %q, exported %v", test.key, alias, test.exported)
+		}
+		again, _ := n.AliasIdent(test.key, test.exported)
+		if lookup, _ := n.Lookup(test.key); again != alias || lookup != alias {
+			t.Errorf("%s aliased to %q then %q, looked up %q", test.key, alias, again, lookup)
+		}
+	}
+}
+
+func TestNamerAliasAll(t *testing.T) {
+	n := NewSeededNamer(8, "seed")
+	a, err := n.AliasAll([]string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := n.AliasAll([]string{"b", "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, _ := n.Lookup("c")
+	if a != b || c != a {
+		t.Errorf("got %q, %q and %q, want one alias", a, b, c)
+	}
+	if _, err := n.AliasAll([]string{a, mustAlias(t, n, "d")}); err != ErrMultipleAlias {
+		t.Errorf("aliasing two aliases: got %v, want %v", err, ErrMultipleAlias)
+	}
+}
+
+func mustAlias(t *testing.T, n *Namer, name string) string {
+	t.Helper()
+	alias, err := n.Alias(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return alias
+}
@@ -0,0 +1,451 @@
+package obfuscator
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/format"
+	"go/parser"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// packageJob is everything written for one package. The rewrite fills it in
+// as it walks the graph and workers write every job once the whole graph is
+// renamed, aliases are never handed out while writing
+type packageJob struct {
+	pkg   *build.Package
+	dir   string
+	tests bool
+	chain []string
+
+	// aliases the package's identifiers were renamed to, including those
+	// of imported identifiers, cached rewrites compare them
+	aliases map[string]string
+
+	files     []*outputFile
+	generated []*generatedFile
+	// tags given to reflected fields, cached rewrites compare them too
+	tags []string
+}
+
+// outputFile is a rewritten go file and the name it's written under
+type outputFile struct {
+	src         string
+	alias       string
+	constraints []string
+	file        *ast.File
+}
+
+// generatedFile is a rewritten non-Go source or a generated go file
+type generatedFile struct {
+	name string
+	data []byte
+}
+
+// fail wraps err with the package the job writes
+func (job *packageJob) fail(kind ErrorKind, path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{
+		Kind:        kind,
+		Path:        path,
+		ImportChain: job.chain,
+		Err:         err,
+	}
+}
+
+// workers is the number of goroutines parsing and writing packages
+func (r *rewriter) workers() int {
+	if r.options.Workers > 0 {
+		return r.options.Workers
+	}
+	return runtime.NumCPU()
+}
+
+// runWorkers calls fn with every index below n from a pool of workers and
+// returns the errors by index. Once one fails no more calls are started
+// unless errors are collected
+func (r *rewriter) runWorkers(n int, fn func(i int) error) []error {
+	errs := make([]error, n)
+	indexes := make(chan int)
+	var failed int32
+	var wg sync.WaitGroup
+	for w := 0; w < r.workers(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if atomic.LoadInt32(&failed) != 0 && !r.options.CollectErrors {
+					continue
+				}
+				if errs[i] = fn(i); errs[i] != nil {
+					atomic.StoreInt32(&failed, 1)
+				}
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return errs
+}
+
+// discoverPackages walks the import graph from pkg with go/build alone and
+// returns every package the rewrite will reach. imports that can't be
+// resolved are reported by the rewrite
+func (r *rewriter) discoverPackages(pkg *build.Package) []*build.Package {
+	seen := make(map[string]struct{})
+	var pkgs []*build.Package
+	var visit func(pkg *build.Package)
+	visit = func(pkg *build.Package) {
+		if _, ok := seen[pkg.Dir]; ok || pkg.Goroot {
+			return
+		}
+		seen[pkg.Dir] = struct{}{}
+		pkgs = append(pkgs, pkg)
+
+		imports := append([]string{}, pkg.Imports...)
+		if r.options.Tests && r.isInternal(pkg) {
+			imports = mergeNames(imports, pkg.TestImports)
+			imports = mergeNames(imports, pkg.XTestImports)
+		}
+		for _, importPath := range imports {
+			if importPath == "C" {
+				continue
+			}
+			if dep, err := r.importPackage(importPath, pkg.Dir); err == nil {
+				visit(dep)
+			}
+		}
+	}
+	visit(pkg)
+	return pkgs
+}
+
+// parsePackages parses the go files of pkgs concurrently ahead of the
+// rewrite, which finds them already parsed. files that fail are parsed again
+// by the rewrite to report them
+func (r *rewriter) parsePackages(pkgs []*build.Package) {
+	var paths []string
+	add := func(dir string, names []string) {
+		for _, name := range names {
+			paths = append(paths, filepath.Join(dir, name))
+		}
+	}
+	for _, pkg := range pkgs {
+		add(pkg.Dir, pkg.GoFiles)
+		add(pkg.Dir, pkg.CgoFiles)
+		if r.options.Tests && r.isInternal(pkg) {
+			add(pkg.Dir, pkg.TestGoFiles)
+			add(pkg.Dir, pkg.XTestGoFiles)
+		}
+	}
+
+	files := make([]*ast.File, len(paths))
+	r.runWorkers(len(paths), func(i int) error {
+		if _, ok := r.parsed[paths[i]]; ok {
+			return nil
+		}
+		code, err := ioutil.ReadFile(paths[i])
+		if err != nil {
+			return nil
+		}
+		if file, err := parser.ParseFile(r.fset, paths[i], code, parseMode); err == nil {
+			files[i] = file
+		}
+		return nil
+	})
+	for i, file := range files {
+		if file != nil {
+			r.parsed[paths[i]] = file
+		}
+	}
+}
+
+// writePackages writes every job from a pool of workers. With a cache, jobs
+// whose hash matches what was last written are left as they are
+func (r *rewriter) writePackages() error {
+	hashes := make([]string, len(r.jobs))
+	errs := r.runWorkers(len(r.jobs), func(i int) error {
+		job := r.jobs[i]
+		if r.cache == nil {
+			return r.writePackage(job)
+		}
+		hash, err := r.jobHash(job)
+		if err != nil {
+			return job.fail(ResolveError, job.pkg.Dir, err)
+		}
+		if r.cache.Packages[job.dir] == hash {
+			if _, err := os.Stat(job.dir); err == nil {
+				return nil
+			}
+		}
+		if err := r.writePackage(job); err != nil {
+			return err
+		}
+		hashes[i] = hash
+		return nil
+	})
+
+	if r.cache != nil {
+		// jobs that weren't run keep their entries, their directories weren't
+		// touched
+		for i, job := range r.jobs {
+			if errs[i] != nil {
+				delete(r.cache.Packages, job.dir)
+			} else if hashes[i] != "" {
+				r.cache.Packages[job.dir] = hashes[i]
+			}
+		}
+		if err := r.removeStalePackages(); err != nil {
+			return r.fail(WriteError, r.options.TargetPath, err)
+		}
+		if err := r.cache.save(r.options.CachePath); err != nil {
+			return r.fail(WriteError, r.options.CachePath, err)
+		}
+	}
+
+	for _, err := range errs {
+		if err := r.collect(err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writePackage copies the non-Go files of the package and the paths under
+// it its sources use to its target directory and writes its rewritten files
+// next to them. what an earlier rewrite of the package left is removed first
+func (r *rewriter) writePackage(job *packageJob) error {
+	pkg := job.pkg
+	if err := r.cleanTargetDir(job); err != nil {
+		return job.fail(WriteError, job.dir, err)
+	}
+	if err := os.MkdirAll(job.dir, 0755); err != nil {
+		return job.fail(WriteError, job.dir, err)
+	}
+	if err := copyFiles(pkg.Dir, job.dir, pkg.IgnoredOtherFiles); err != nil {
+		return job.fail(WriteError, job.dir, err)
+	}
+	for _, rel := range r.usedPaths(job) {
+		if err := copyPath(filepath.Join(pkg.Dir, rel), filepath.Join(job.dir, rel)); err != nil {
+			return job.fail(WriteError, job.dir, err)
+		}
+	}
+
+	for _, g := range job.generated {
+		name := path.Join(job.dir, g.name)
+		if err := ioutil.WriteFile(name, g.data, 0644); err != nil {
+			return job.fail(WriteError, name, err)
+		}
+	}
+	for _, f := range job.files {
+		if err := r.writeFile(job, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cleanTargetDir removes what an earlier rewrite of the job left in its
+// target directory: the files directly in it, go files written under other
+// aliases included, and the paths under it the package copies. packages
+// under it are jobs of their own so their directories are left alone
+func (r *rewriter) cleanTargetDir(job *packageJob) error {
+	if err := removeDirFiles(job.dir); err != nil {
+		return err
+	}
+	for _, rel := range r.usedPaths(job) {
+		dir := filepath.Join(job.dir, rel)
+		if r.holdsPackage(dir) {
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// holdsPackage reports whether dir is, or is above, the target directory of
+// a package the rewrite writes
+func (r *rewriter) holdsPackage(dir string) bool {
+	for _, job := range r.jobs {
+		if job.dir == dir || strings.HasPrefix(job.dir, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeDirFiles removes the files directly in dir but not its directories.
+// a dir that doesn't exist is skipped
+func removeDirFiles(dir string) error {
+	infos, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if info.IsDir() {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, info.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// usedPaths are the paths under the directory of the package its sources
+// include or embed, and its test data when its tests are written
+func (r *rewriter) usedPaths(job *packageJob) []string {
+	pkg := job.pkg
+	var patterns []string
+	patterns = append(patterns, pkg.EmbedPatterns...)
+	if job.tests {
+		patterns = append(patterns, pkg.TestEmbedPatterns...)
+		patterns = append(patterns, pkg.XTestEmbedPatterns...)
+	}
+
+	var paths []string
+	for rel := range r.copies[pkg.Dir] {
+		paths = append(paths, rel)
+	}
+	for _, pattern := range patterns {
+		pattern = filepath.FromSlash(strings.TrimPrefix(pattern, "all:"))
+		matches, _ := filepath.Glob(filepath.Join(pkg.Dir, pattern))
+		for _, match := range matches {
+			if rel, err := filepath.Rel(pkg.Dir, match); err == nil {
+				paths = append(paths, rel)
+			}
+		}
+	}
+	if job.tests {
+		paths = append(paths, "testdata")
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// copyFiles copies the regular files directly in src to dst but for go
+// files, which are only written rewritten, module files and those skipped
+func copyFiles(src, dst string, skip []string) error {
+	infos, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	skipped := make(map[string]struct{})
+	for _, name := range skip {
+		skipped[name] = struct{}{}
+	}
+	for _, info := range infos {
+		name := info.Name()
+		if _, ok := skipped[name]; ok || !info.Mode().IsRegular() || skipCopy(name) {
+			continue
+		}
+		if err := copyFile(filepath.Join(src, name), filepath.Join(dst, name), info.Mode()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyPath copies a file, or a directory and the directories under it that
+// aren't vendored or modules of their own, without their go files. paths
+// that don't exist are skipped
+func copyPath(src, dst string) error {
+	info, err := os.Stat(src)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		return copyFile(src, dst, info.Mode())
+	}
+
+	return filepath.Walk(src, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, name)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			if !info.Mode().IsRegular() || skipCopy(info.Name()) {
+				return nil
+			}
+			return copyFile(name, filepath.Join(dst, rel), info.Mode())
+		}
+		if rel != "." && skipCopyDir(name) {
+			return filepath.SkipDir
+		}
+		return os.MkdirAll(filepath.Join(dst, rel), 0755)
+	})
+}
+
+// skipCopyDir reports whether a directory under a copied path is vendored or
+// a module of its own, neither belongs to the package
+func skipCopyDir(dir string) bool {
+	if filepath.Base(dir) == "vendor" {
+		return true
+	}
+	_, err := os.Stat(filepath.Join(dir, "go.mod"))
+	return err == nil
+}
+
+// skipCopy reports whether a file is a go file or describes a module,
+// neither is copied as it is
+func skipCopy(name string) bool {
+	return strings.HasSuffix(name, ".go") || name == "go.mod" || name == "go.sum"
+}
+
+// copyFile copies a regular file
+func copyFile(src, dst string, mode os.FileMode) error {
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dst, data, mode.Perm())
+}
+
+// writeFile writes the rewritten version of a go file
+func (r *rewriter) writeFile(job *packageJob, f *outputFile) error {
+	newPath := path.Join(job.dir, f.alias)
+	fw, err := os.OpenFile(newPath, os.O_RDWR|os.O_TRUNC|os.O_CREATE, 0644)
+	if err != nil {
+		return job.fail(WriteError, newPath, err)
+	}
+	defer fw.Close()
+	if len(f.constraints) > 0 {
+		if _, err := fmt.Fprintf(fw, "%s\n\n", strings.Join(f.constraints, "\n")); err != nil {
+			return job.fail(WriteError, newPath, err)
+		}
+	}
+	if r.options.LineDirectives {
+		if err := formatWithLines(fw, r.fset, f.file, f.src, f.alias); err != nil {
+			return job.fail(WriteError, newPath, err)
+		}
+	} else if err := format.Node(fw, r.fset, f.file); err != nil {
+		return job.fail(WriteError, newPath, err)
+	}
+
+	return job.fail(WriteError, newPath, fw.Close())
+}
@@ -0,0 +1,160 @@
+package obfuscator
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRunWorkers(t *testing.T) {
+	for _, workers := range []int{1, 4} {
+		r := &rewriter{options: Options{Workers: workers}}
+		var calls int32
+		errs := r.runWorkers(50, func(i int) error {
+			atomic.AddInt32(&calls, 1)
+			if i%10 == 3 {
+				return fmt.Errorf("job %d", i)
+			}
+			return nil
+		})
+		if len(errs) != 50 {
+			t.Fatalf("%d workers: got %d errors, want one per index", workers, len(errs))
+		}
+		for i, err := range errs {
+			if err != nil && err.Error() != fmt.Sprintf("job %d", i) {
+				t.Errorf("%d workers: error %v reported for index %d", workers, err, i)
+			}
+		}
+		if calls == 50 && workers == 1 {
+			t.Errorf("%d workers kept starting jobs after a failure", workers)
+		}
+	}
+
+	r := &rewriter{options: Options{Workers: 4, CollectErrors: true}}
+	failed := errors.New("failed")
+	errs := r.runWorkers(50, func(i int) error { return failed })
+	for i, err := range errs {
+		if err != failed {
+			t.Fatalf("index %d wasn't run while collecting errors", i)
+		}
+	}
+}
+
+func TestRewriteWorkersDeterministic(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"go.mod": "module example.com/pool\n\ngo 1.16\n",
+		"cmd/app/main.go": `package main
+
+import (
+	"fmt"
+
+	"example.com/pool/a"
+	"example.com/pool/b"
+	"example.com/pool/c"
+)
+
+func main() { fmt.Println(a.Name(), b.Name(), c.Name()) }
+`,
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		files[name+"/"+name+".go"] = fmt.Sprintf(`package %s
+
+import "example.com/pool/shared"
+
+type value struct{ Label string }
+
+// Name labels the package
+func Name() string { return shared.Prefix + value{Label: "%s"}.Label }
+`, name, name)
+	}
+	files["shared/shared.go"] = "package shared\n\n// Prefix starts every label\nconst Prefix = \"pkg-\"\n"
+	writeTree(t, root, files)
+
+	var trees []map[string]string
+	for _, workers := range []int{1, 8} {
+		target := t.TempDir()
+		options := Options{
+			SrcPath:        filepath.Join(root, "cmd", "app"),
+			RootPath:       root,
+			TargetPath:     target,
+			RenameExported: true,
+			EncryptStrings: true,
+			Seed:           "workers",
+			Workers:        workers,
+		}
+		if out := runTarget(t, options); out != "pkg-a pkg-b pkg-c\n" {
+			t.Errorf("%d workers: target printed %q", workers, out)
+		}
+
+		tree := make(map[string]string)
+		for _, name := range targetFiles(t, target) {
+			data, err := ioutil.ReadFile(filepath.Join(target, filepath.FromSlash(name)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tree[name] = string(data)
+		}
+		trees = append(trees, tree)
+	}
+
+	if len(trees[0]) != len(trees[1]) {
+		t.Fatalf("targets have %d and %d files", len(trees[0]), len(trees[1]))
+	}
+	for name, data := range trees[0] {
+		if other, ok := trees[1][name]; !ok {
+			t.Errorf("%s is missing with 8 workers", name)
+		} else if other != data {
+			t.Errorf("%s differs between worker counts", name)
+		}
+	}
+}
+
+func TestRewriteKeptRootPackage(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"go.mod": "module example.com/kept\n\ngo 1.16\n",
+		"kept.go": `package kept
+
+// Name names the module
+func Name() string { return "kept" }
+`,
+		"cmd/app/main.go": `package main
+
+import (
+	"fmt"
+
+	"example.com/kept"
+)
+
+func main() { fmt.Println(kept.Name()) }
+`,
+		"cmd/app/data.txt": "data",
+	})
+
+	target := t.TempDir()
+	options := Options{
+		SrcPath:           filepath.Join(root, "cmd", "app"),
+		RootPath:          root,
+		TargetPath:        target,
+		RenameIdentifiers: true,
+		KeepPaths:         true,
+		Workers:           1,
+	}
+	// the second rewrite finds the files of the first in place
+	for i := 0; i < 2; i++ {
+		if out := runTarget(t, options); out != "kept\n" {
+			t.Fatalf("rewrite %d: target printed %q", i, out)
+		}
+	}
+
+	want := []string{"cmd/app/data.txt", "cmd/app/main.go", "go.mod", "kept.go"}
+	got := targetFiles(t, target)
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("target has %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,87 @@
+package obfuscator
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Plan is the layout a rewrite writes to the target tree, recorded by every
+// rewrite and returned on its own by PlanRewrite
+type Plan struct {
+	Packages []*PlannedPackage `json:"packages"`
+	Tags     []*TagChange      `json:"tags,omitempty"`
+	// Flattened are the functions whose control flow was flattened
+	Flattened []string `json:"flattened,omitempty"`
+	Mapping   *Mapping `json:"mapping"`
+}
+
+// PlannedPackage is a package and the aliased copy it's rewritten to
+type PlannedPackage struct {
+	ImportPath       string         `json:"importPath"`
+	Dir              string         `json:"dir"`
+	TargetImportPath string         `json:"targetImportPath"`
+	TargetDir        string         `json:"targetDir"`
+	Files            []*PlannedFile `json:"files"`
+}
+
+// PlannedFile is a go file of a package, the name it's written under and
+// the aliases its imports are rewritten to
+type PlannedFile struct {
+	Name    string            `json:"name"`
+	Target  string            `json:"target"`
+	Imports map[string]string `json:"imports,omitempty"`
+}
+
+// PlanRewrite traverses and aliases the project like Rewrite without
+// writing anything and returns what would have been written
+func PlanRewrite(options Options) (*Plan, error) {
+	options.DryRun = true
+	r, err := rewrite(options)
+	if err != nil {
+		return nil, err
+	}
+	return r.plan, nil
+}
+
+// WriteText writes every package, its files and their rewritten imports
+func (p *Plan) WriteText(w io.Writer) error {
+	files := 0
+	for _, pkg := range p.Packages {
+		if _, err := fmt.Fprintf(w, "%s -> %s (%s)\n", pkg.ImportPath, pkg.TargetImportPath, pkg.TargetDir); err != nil {
+			return err
+		}
+		for _, file := range pkg.Files {
+			if _, err := fmt.Fprintf(w, "\t%s -> %s\n", file.Name, file.Target); err != nil {
+				return err
+			}
+			var imports []string
+			for imp := range file.Imports {
+				imports = append(imports, imp)
+			}
+			sort.Strings(imports)
+			for _, imp := range imports {
+				if _, err := fmt.Fprintf(w, "\t\t%s -> %s\n", imp, file.Imports[imp]); err != nil {
+					return err
+				}
+			}
+		}
+		files += len(pkg.Files)
+	}
+	for _, name := range p.Flattened {
+		if _, err := fmt.Fprintf(w, "flattened %s\n", name); err != nil {
+			return err
+		}
+	}
+	for _, tag := range p.Tags {
+		after := tag.After
+		if after == "" {
+			after = "(stripped)"
+		}
+		if _, err := fmt.Fprintf(w, "%s.%s %s -> %s\n", tag.Type, tag.Field, tag.Before, after); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%d packages, %d files, %d identifiers\n", len(p.Packages), files, len(p.Mapping.Identifiers))
+	return err
+}
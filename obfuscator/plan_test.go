@@ -0,0 +1,87 @@
+package obfuscator
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPlanRewrite(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"go.mod": "module example.com/plan\n\ngo 1.16\n",
+		"cmd/app/main.go": `package main
+
+import "example.com/plan/lib"
+
+func main() { lib.Run() }
+`,
+		"lib/lib.go":  "package lib\n\nfunc Run() { helper() }\n",
+		"lib/util.go": "package lib\n\nfunc helper() {}\n",
+	})
+	target := filepath.Join(t.TempDir(), "target")
+	mapping := filepath.Join(t.TempDir(), "mapping.json")
+	options := Options{
+		SrcPath:           filepath.Join(root, "cmd", "app"),
+		RootPath:          root,
+		TargetPath:        target,
+		MappingPath:       mapping,
+		RenameIdentifiers: true,
+		Seed:              "plan",
+	}
+
+	plan, err := PlanRewrite(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{target, mapping} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("dry run wrote %s", path)
+		}
+	}
+
+	packages := make(map[string]*PlannedPackage)
+	for _, pkg := range plan.Packages {
+		packages[pkg.ImportPath] = pkg
+	}
+	app, lib := packages["example.com/plan/cmd/app"], packages["example.com/plan/lib"]
+	if app == nil || lib == nil {
+		t.Fatalf("plan has %v, want cmd/app and lib", plan.Packages)
+	}
+	if len(app.Files) != 1 || len(lib.Files) != 2 {
+		t.Fatalf("plan has %d and %d files, want 1 and 2", len(app.Files), len(lib.Files))
+	}
+	if got := app.Files[0].Imports["example.com/plan/lib"]; got != lib.TargetImportPath {
+		t.Errorf("main.go imports lib as %q, want %q", got, lib.TargetImportPath)
+	}
+	if len(plan.Mapping.Identifiers) == 0 {
+		t.Error("plan has no renamed identifiers")
+	}
+
+	var text bytes.Buffer
+	if err := plan.WriteText(&text); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text.String(), "example.com/plan/lib -> "+lib.TargetImportPath) ||
+		!strings.HasSuffix(text.String(), "2 packages, 3 files, "+strconv.Itoa(len(plan.Mapping.Identifiers))+" identifiers\n") {
+		t.Errorf("unexpected plan text:\n%s", text.String())
+	}
+
+	// a seeded rewrite writes exactly what was planned
+	if _, err := Rewrite(options); err != nil {
+		t.Fatal(err)
+	}
+	for _, pkg := range plan.Packages {
+		for _, file := range pkg.Files {
+			if _, err := os.Stat(filepath.Join(pkg.TargetDir, file.Target)); err != nil {
+				t.Errorf("planned %s wasn't written: %v", file.Name, err)
+			}
+		}
+	}
+	if _, err := os.Stat(mapping); err != nil {
+		t.Errorf("rewrite didn't write the mapping: %v", err)
+	}
+}
@@ -0,0 +1,366 @@
+package obfuscator
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"go/ast"
+	"go/build"
+	"go/build/constraint"
+	"go/types"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// errors
+var (
+	ErrPlatform = errors.New("platforms must be given as GOOS/GOARCH")
+)
+
+// platform is the build context of one target platform and tag set along
+// with the packages loaded and checked for it
+type platform struct {
+	rewriter *rewriter
+	context  build.Context
+	sizes    types.Sizes
+
+	loaded  map[string]*loadedPackage
+	checked map[string]*loadedPackage
+	std     map[string]*types.Package
+	failed  map[string]error
+
+	// importErr is the first import that failed while checking a package,
+	// the type checker only reports it as text
+	importErr error
+}
+
+// newPlatforms builds a platform for every combination of Options.Platforms
+// and Options.Tags. Without either the rewriter's own context is the only
+// platform. Like the go command, cgo is only enabled for the host platform
+func (r *rewriter) newPlatforms() error {
+	platforms := r.options.Platforms
+	if len(platforms) == 0 {
+		platforms = []string{r.context.GOOS + "/" + r.context.GOARCH}
+	}
+	tags := r.options.Tags
+	if len(tags) == 0 {
+		tags = []string{""}
+	}
+
+	for _, target := range platforms {
+		parts := strings.Split(target, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return &Error{Kind: OptionsError, Path: target, Err: ErrPlatform}
+		}
+		for _, tagSet := range tags {
+			context := r.context
+			context.GOOS, context.GOARCH = parts[0], parts[1]
+			context.BuildTags = nil
+			for _, tag := range strings.Split(tagSet, ",") {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					context.BuildTags = append(context.BuildTags, tag)
+				}
+			}
+			if context.GOOS != r.context.GOOS || context.GOARCH != r.context.GOARCH {
+				context.CgoEnabled = false
+			}
+
+			r.platforms = append(r.platforms, &platform{
+				rewriter: r,
+				context:  context,
+				sizes:    types.SizesFor("gc", context.GOARCH),
+				loaded:   make(map[string]*loadedPackage),
+				checked:  make(map[string]*loadedPackage),
+				std:      make(map[string]*types.Package),
+				failed:   make(map[string]error),
+			})
+		}
+	}
+	return nil
+}
+
+// variant type-checks the package in pkg's directory as it builds for the
+// platform, or returns nil if none of its files do
+func (pl *platform) variant(pkg *build.Package) (*loadedPackage, error) {
+	bp, err := pl.context.ImportDir(pkg.Dir, 0)
+	if _, ok := err.(*build.NoGoError); ok {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	bp.ImportPath = pkg.ImportPath
+	return pl.checkPackage(bp)
+}
+
+// Import implements types.Importer
+func (pl *platform) Import(path string) (*types.Package, error) {
+	return pl.ImportFrom(path, pl.context.Dir, 0)
+}
+
+// ImportFrom implements types.ImporterFrom. packages are resolved with the
+// platform's build context so vendor directories, modules and build
+// constraints match the rewrite
+func (pl *platform) ImportFrom(path, dir string, mode types.ImportMode) (*types.Package, error) {
+	if path == "unsafe" {
+		return types.Unsafe, nil
+	}
+
+	p, err := pl.importFrom(path, dir)
+	if err != nil && pl.importErr == nil {
+		pl.importErr = err
+	}
+	return p, err
+}
+
+func (pl *platform) importFrom(path, dir string) (*types.Package, error) {
+	r := pl.rewriter
+	pkg, err := pl.context.Import(path, dir, 0)
+	if err != nil {
+		return nil, r.fail(ResolveError, path, err)
+	}
+	if err := r.resolveImportPath(pkg); err != nil {
+		return nil, r.fail(ResolveError, pkg.Dir, err)
+	}
+
+	if pkg.Goroot {
+		return pl.checkStd(pkg)
+	}
+
+	p, err := pl.checkPackage(pkg)
+	if err != nil {
+		return nil, err
+	}
+	return p.types, nil
+}
+
+// checkStd type-checks a standard library package for the platform. only
+// declarations are needed so function bodies are skipped and, like the
+// source importer, soft errors are ignored
+func (pl *platform) checkStd(pkg *build.Package) (*types.Package, error) {
+	if p, ok := pl.std[pkg.ImportPath]; ok {
+		return p, nil
+	}
+
+	var paths []string
+	paths = append(paths, pkg.GoFiles...)
+	paths = append(paths, pkg.CgoFiles...)
+	prefixDirectory(pkg.Dir, paths)
+	files, err := pl.rewriter.parseFiles(paths, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var hardErr error
+	conf := types.Config{
+		Importer:         pl,
+		FakeImportC:      true,
+		IgnoreFuncBodies: true,
+		Sizes:            pl.sizes,
+		Error: func(err error) {
+			if terr, ok := err.(types.Error); hardErr == nil && (!ok || !terr.Soft) {
+				hardErr = err
+			}
+		},
+	}
+	p, _ := conf.Check(pkg.ImportPath, pl.rewriter.fset, files, nil)
+	if hardErr != nil {
+		return nil, pl.rewriter.fail(CheckError, pkg.Dir, hardErr)
+	}
+	pl.std[pkg.ImportPath] = p
+	return p, nil
+}
+
+// importPackage resolves an import path for every platform and merges the
+// files each one builds
+func (r *rewriter) importPackage(path, srcDir string) (*build.Package, error) {
+	return r.unionPackage(func(context *build.Context) (*build.Package, error) {
+		return context.Import(path, srcDir, 0)
+	})
+}
+
+// importDir is importPackage for a package directory
+func (r *rewriter) importDir(dir string) (*build.Package, error) {
+	return r.unionPackage(func(context *build.Context) (*build.Package, error) {
+		return context.ImportDir(dir, 0)
+	})
+}
+
+// unionPackage imports a package with every platform's context. The result
+// is the first platform's package listing every file any platform builds,
+// files no platform builds are left ignored
+func (r *rewriter) unionPackage(importFn func(*build.Context) (*build.Package, error)) (*build.Package, error) {
+	var union *build.Package
+	var firstErr error
+	for _, pl := range r.platforms {
+		pkg, err := importFn(&pl.context)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if union == nil {
+			union = pkg
+			continue
+		}
+
+		lists := []struct{ dst, src *[]string }{
+			{&union.GoFiles, &pkg.GoFiles},
+			{&union.CgoFiles, &pkg.CgoFiles},
+			{&union.TestGoFiles, &pkg.TestGoFiles},
+			{&union.XTestGoFiles, &pkg.XTestGoFiles},
+			{&union.CFiles, &pkg.CFiles},
+			{&union.CXXFiles, &pkg.CXXFiles},
+			{&union.MFiles, &pkg.MFiles},
+			{&union.HFiles, &pkg.HFiles},
+			{&union.FFiles, &pkg.FFiles},
+			{&union.SFiles, &pkg.SFiles},
+			{&union.SwigFiles, &pkg.SwigFiles},
+			{&union.SwigCXXFiles, &pkg.SwigCXXFiles},
+			{&union.SysoFiles, &pkg.SysoFiles},
+			{&union.Imports, &pkg.Imports},
+			{&union.TestImports, &pkg.TestImports},
+			{&union.XTestImports, &pkg.XTestImports},
+		}
+		for _, list := range lists {
+			*list.dst = mergeNames(*list.dst, *list.src)
+		}
+	}
+	if union == nil {
+		return nil, firstErr
+	}
+
+	if err := r.resolveImportPath(union); err != nil {
+		return nil, err
+	}
+
+	union.IgnoredGoFiles = unbuilt(union.IgnoredGoFiles, union.GoFiles, union.CgoFiles, union.TestGoFiles, union.XTestGoFiles)
+	union.IgnoredOtherFiles = unbuilt(union.IgnoredOtherFiles, union.CFiles, union.CXXFiles, union.MFiles, union.HFiles, union.FFiles, union.SFiles, union.SwigFiles, union.SwigCXXFiles, union.SysoFiles)
+
+	return union, nil
+}
+
+// unbuilt filters the names in ignored some platform builds after all
+func unbuilt(ignored []string, built ...[]string) []string {
+	seen := make(map[string]struct{})
+	for _, names := range built {
+		for _, name := range names {
+			seen[name] = struct{}{}
+		}
+	}
+	var names []string
+	for _, name := range ignored {
+		if _, ok := seen[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func mergeNames(a, b []string) []string {
+	seen := make(map[string]struct{}, len(a))
+	for _, name := range a {
+		seen[name] = struct{}{}
+	}
+	for _, name := range b {
+		if _, ok := seen[name]; !ok {
+			seen[name] = struct{}{}
+			a = append(a, name)
+		}
+	}
+	sort.Strings(a)
+	return a
+}
+
+// lookupObject finds a package level object declared for any platform
+func (r *rewriter) lookupObject(path, name string) types.Object {
+	for _, pl := range r.platforms {
+		if p, ok := pl.checked[path]; ok {
+			if obj := p.types.Scope().Lookup(name); obj != nil {
+				return obj
+			}
+		}
+	}
+	return nil
+}
+
+// buildConstraints returns the //go:build and // +build lines of a go file.
+// they're comments so the rewritten file would lose them otherwise
+func buildConstraints(filename string) ([]string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if constraint.IsGoBuild(line) || constraint.IsPlusBuild(line) {
+			lines = append(lines, line)
+			continue
+		}
+		// constraints have to come before the package clause
+		if strings.HasPrefix(line, "package ") {
+			break
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// dropConstraints removes the build constraint comments ahead of the package
+// clause of file
+func dropConstraints(file *ast.File) {
+	var groups []*ast.CommentGroup
+	for _, group := range file.Comments {
+		if group.Pos() < file.Package {
+			var list []*ast.Comment
+			for _, c := range group.List {
+				if !constraint.IsGoBuild(c.Text) && !constraint.IsPlusBuild(c.Text) {
+					list = append(list, c)
+				}
+			}
+			if len(list) == 0 {
+				continue
+			}
+			group.List = list
+		}
+		groups = append(groups, group)
+	}
+	file.Comments = groups
+}
+
+// platformSuffix returns the _GOOS, _GOARCH or _GOOS_GOARCH suffix of a go
+// file name, which constrains the file like a build tag
+func platformSuffix(name string) string {
+	name = strings.TrimSuffix(name, ".go")
+	name = strings.TrimSuffix(name, "_test")
+
+	parts := strings.Split(name, "_")
+	if len(parts) >= 3 && knownOS[parts[len(parts)-2]] && knownArch[parts[len(parts)-1]] {
+		return "_" + parts[len(parts)-2] + "_" + parts[len(parts)-1]
+	}
+	if len(parts) >= 2 && (knownOS[parts[len(parts)-1]] || knownArch[parts[len(parts)-1]]) {
+		return "_" + parts[len(parts)-1]
+	}
+	return ""
+}
+
+// file name suffixes go/build recognises, past ports included. Do not remove
+// from this list, as it is used for filename matching
+var knownOS = map[string]bool{
+	"aix": true, "android": true, "darwin": true, "dragonfly": true,
+	"freebsd": true, "hurd": true, "illumos": true, "ios": true, "js": true,
+	"linux": true, "nacl": true, "netbsd": true, "openbsd": true, "plan9": true,
+	"solaris": true, "wasip1": true, "windows": true, "zos": true,
+}
+
+var knownArch = map[string]bool{
+	"386": true, "amd64": true, "amd64p32": true, "arm": true, "armbe": true,
+	"arm64": true, "arm64be": true, "loong64": true, "mips": true,
+	"mipsle": true, "mips64": true, "mips64le": true, "mips64p32": true,
+	"mips64p32le": true, "ppc": true, "ppc64": true, "ppc64le": true,
+	"riscv": true, "riscv64": true, "s390": true, "s390x": true, "sparc": true,
+	"sparc64": true, "wasm": true,
+}
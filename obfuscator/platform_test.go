@@ -0,0 +1,184 @@
+package obfuscator
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestPlatformSuffix(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"sys.go", ""},
+		{"linux.go", ""},
+		{"sys_debug.go", ""},
+		{"sys_linux.go", "_linux"},
+		{"sys_arm64.go", "_arm64"},
+		{"sys_windows_amd64.go", "_windows_amd64"},
+		{"sys_linux_test.go", "_linux"},
+		{"sys_amd64_linux.go", "_linux"},
+		{"sys_nacl_amd64p32.go", "_nacl_amd64p32"},
+	}
+	for _, test := range tests {
+		if got := platformSuffix(test.name); got != test.want {
+			t.Errorf("platformSuffix(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestPlatformsInvalid(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"go.mod":          "module example.com/bad\n\ngo 1.16\n",
+		"cmd/app/main.go": "package main\n\nfunc main() {}\n",
+	})
+	for _, platform := range []string{"linux", "linux/", "/amd64", "linux/amd64/v2"} {
+		_, err := Rewrite(Options{
+			SrcPath:    filepath.Join(root, "cmd", "app"),
+			RootPath:   root,
+			TargetPath: t.TempDir(),
+			Platforms:  []string{platform},
+		})
+		if err == nil || !strings.Contains(err.Error(), ErrPlatform.Error()) {
+			t.Errorf("platform %q: got %v, want %v", platform, err, ErrPlatform)
+		}
+	}
+}
+
+func platformProject(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"go.mod": "module example.com/plat\n\ngo 1.16\n",
+		"cmd/app/main.go": `package main
+
+import (
+	"fmt"
+
+	"example.com/plat/sys"
+)
+
+func main() { fmt.Println(sys.Describe()) }
+`,
+		"sys/sys.go": `package sys
+
+// Describe names the platform and build mode
+func Describe() string { return osName() + " " + buildMode }
+`,
+		"sys/sys_linux.go": `package sys
+
+func osName() string { return "linux" }
+`,
+		"sys/sys_windows.go": `package sys
+
+func osName() string { return "windows" }
+`,
+		"sys/mode_debug.go": `//go:build debug
+
+package sys
+
+const buildMode = "debug"
+`,
+		"sys/mode_release.go": `//go:build !debug
+
+package sys
+
+const buildMode = "release"
+`,
+	})
+	return root
+}
+
+func TestRewritePlatforms(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the host build is expected to be linux")
+	}
+	root := platformProject(t)
+	target := t.TempDir()
+	options := Options{
+		SrcPath:           filepath.Join(root, "cmd", "app"),
+		RootPath:          root,
+		TargetPath:        target,
+		RenameIdentifiers: true,
+		Platforms:         []string{"linux/amd64", "windows/amd64"},
+		Tags:              []string{"", "debug"},
+	}
+	if out := runTarget(t, options); out != "linux release\n" {
+		t.Errorf("target printed %q, want %q", out, "linux release\n")
+	}
+
+	// a fresh target so the files of the first rewrite don't mix in
+	target = t.TempDir()
+	options.TargetPath = target
+	alias, err := Rewrite(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	builds := []struct {
+		env  []string
+		args []string
+	}{
+		{[]string{"GOOS=windows", "GOARCH=amd64"}, nil},
+		{[]string{"GOOS=linux", "GOARCH=amd64"}, []string{"-tags", "debug"}},
+	}
+	for _, b := range builds {
+		args := append([]string{"build", "-o", os.DevNull}, b.args...)
+		cmd := exec.Command("go", append(args, alias)...)
+		cmd.Dir = target
+		cmd.Env = append(append(os.Environ(), "GO111MODULE=on", "CGO_ENABLED=0"), b.env...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Errorf("%v go build %v: %v\n%s", b.env, b.args, err, out)
+		}
+	}
+
+	var windows, constraints int
+	for _, name := range targetFiles(t, target) {
+		if strings.HasSuffix(name, "_windows.go") {
+			windows++
+		}
+		data, err := ioutil.ReadFile(filepath.Join(target, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), "//go:build debug") || strings.Contains(string(data), "//go:build !debug") {
+			constraints++
+		}
+		if strings.Contains(string(data), "osName") || strings.Contains(string(data), "buildMode") {
+			t.Errorf("%s still mentions an original name", name)
+		}
+	}
+	if windows != 1 {
+		t.Errorf("target has %d _windows.go files, want 1", windows)
+	}
+	if constraints != 2 {
+		t.Errorf("target has %d constrained files, want 2", constraints)
+	}
+}
+
+func TestRewriteHostPlatform(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the host build is expected to be linux")
+	}
+	root := platformProject(t)
+	target := t.TempDir()
+	out := runTarget(t, Options{
+		SrcPath:           filepath.Join(root, "cmd", "app"),
+		RootPath:          root,
+		TargetPath:        target,
+		RenameIdentifiers: true,
+	})
+	if out != "linux release\n" {
+		t.Errorf("target printed %q, want %q", out, "linux release\n")
+	}
+	for _, name := range targetFiles(t, target) {
+		if strings.HasSuffix(name, "_windows.go") {
+			t.Errorf("%s doesn't build for the host", name)
+		}
+	}
+}
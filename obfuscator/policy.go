@@ -0,0 +1,213 @@
+package obfuscator
+
+import (
+	"errors"
+	"fmt"
+	"go/build"
+	"go/parser"
+	"go/types"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// errors
+var (
+	ErrAliasLength = errors.New("alias length must be positive")
+)
+
+const (
+	// parseMode parses comments along with every go file, directives are
+	// read from them and the comment pass decides what's written back
+	parseMode = parser.AllErrors | parser.ParseComments
+
+	// regexpPrefix marks a rule as a regular expression rather than a glob
+	regexpPrefix = "re:"
+)
+
+// rules match names by globs or regular expressions. In globs ... matches any
+// string, a trailing /... also matches the path before it, * matches any
+// string without a slash and ? any character but a slash. Rules prefixed with
+// re: are regular expressions that have to match the whole name
+type rules []*regexp.Regexp
+
+// compileRules compiles patterns into rules
+func compileRules(patterns []string) (rules, error) {
+	var rs rules
+	for _, pattern := range patterns {
+		expr := pattern
+		if strings.HasPrefix(pattern, regexpPrefix) {
+			expr = strings.TrimPrefix(pattern, regexpPrefix)
+		} else {
+			expr = globExpr(pattern)
+		}
+		// compiled on its own first so errors quote the rule as written
+		if _, err := regexp.Compile(expr); err != nil {
+			return nil, fmt.Errorf("rule %s: %v", pattern, err)
+		}
+		rs = append(rs, regexp.MustCompile("^(?:"+expr+")$"))
+	}
+	return rs, nil
+}
+
+// globExpr translates a glob into a regular expression
+func globExpr(glob string) string {
+	suffix := ""
+	if strings.HasSuffix(glob, "/...") {
+		glob, suffix = strings.TrimSuffix(glob, "/..."), "(/.*)?"
+	}
+	expr := regexp.QuoteMeta(glob)
+	expr = strings.Replace(expr, `\.\.\.`, `.*`, -1)
+	expr = strings.Replace(expr, `\*`, `[^/]*`, -1)
+	expr = strings.Replace(expr, `\?`, `[^/]`, -1)
+	return expr + suffix
+}
+
+// match reports whether any of the rules matches name
+func (rs rules) match(name string) bool {
+	for _, re := range rs {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPackage reports whether any of the rules matches the import path of
+// pkg. vendored packages also match by the path they're imported by
+func (rs rules) matchPackage(pkg *build.Package) bool {
+	return rs.match(pkg.ImportPath) || rs.match(unvendoredPath(pkg.ImportPath))
+}
+
+// unvendoredPath is the path a vendored package is imported by
+func unvendoredPath(importPath string) string {
+	if i := strings.LastIndex(importPath, vendorPath); i != -1 {
+		return importPath[i+len(vendorPath):]
+	}
+	return importPath
+}
+
+// policy holds the compiled rules of the options
+type policy struct {
+	include         rules
+	exclude         rules
+	keepImportPaths rules
+	keepFileNames   rules
+	keepIdentifiers rules
+	internalTypes   rules
+	flatten         rules
+}
+
+// compilePolicy compiles every rule of the options
+func (r *rewriter) compilePolicy() error {
+	o := r.options
+	lists := []struct {
+		dst      *rules
+		patterns []string
+	}{
+		{&r.policy.include, o.Include},
+		{&r.policy.exclude, o.Exclude},
+		{&r.policy.keepImportPaths, o.KeepImportPaths},
+		{&r.policy.keepFileNames, o.KeepFileNames},
+		{&r.policy.keepIdentifiers, o.KeepIdentifiers},
+		{&r.policy.internalTypes, o.InternalTypes},
+		{&r.policy.flatten, o.Flatten},
+	}
+	for _, list := range lists {
+		rs, err := compileRules(list.patterns)
+		if err != nil {
+			return r.fail(OptionsError, "", err)
+		}
+		*list.dst = rs
+	}
+	return nil
+}
+
+// aliasLength is the alias length the options ask for
+func aliasLength(options Options) int {
+	if options.AliasLength == 0 {
+		return defaultAliasLength
+	}
+	return options.AliasLength
+}
+
+// selected reports whether the identifier, string and comment passes
+// rewrite pkg
+func (r *rewriter) selected(pkg *build.Package) bool {
+	if len(r.policy.include) > 0 && !r.policy.include.matchPackage(pkg) {
+		return false
+	}
+	return !r.policy.exclude.matchPackage(pkg)
+}
+
+// keepPath reports whether pkg is copied under its original import path
+func (r *rewriter) keepPath(pkg *build.Package) bool {
+	return r.options.KeepPaths || r.policy.keepImportPaths.matchPackage(pkg)
+}
+
+// keepFileName reports whether the go file name of pkg is written under its
+// original name
+func (r *rewriter) keepFileName(pkg *build.Package, name string) bool {
+	if r.keepPath(pkg) {
+		return true
+	}
+	rs := r.policy.keepFileNames
+	return rs.match(path.Join(pkg.ImportPath, name)) || rs.match(path.Join(unvendoredPath(pkg.ImportPath), name))
+}
+
+// keepModule reports whether m is copied under its original path, which it
+// is if any of its packages keeps its import path
+func (r *rewriter) keepModule(m *Module) bool {
+	if r.options.KeepPaths {
+		return true
+	}
+	_, ok := r.keptModules[moduleKey(m)]
+	return ok
+}
+
+// findKeptModules records the modules of pkgs that keep their paths. they
+// have to be known before any of their packages is aliased
+func (r *rewriter) findKeptModules(pkgs []*build.Package) {
+	if r.modules == nil {
+		return
+	}
+	for _, pkg := range pkgs {
+		if err := r.resolveImportPath(pkg); err != nil || !r.keepPath(pkg) {
+			continue
+		}
+		if m, err := r.modules.Lookup(pkg.Dir); err == nil {
+			r.keptModules[moduleKey(m)] = struct{}{}
+		}
+	}
+}
+
+// keptIdentifiers collects the names declared in p that KeepIdentifiers
+// rules match by the import path of the package and the name, fields and
+// methods included
+func (r *rewriter) keptIdentifiers(p *loadedPackage) map[string]struct{} {
+	names := make(map[string]struct{})
+	if len(r.policy.keepIdentifiers) == 0 || p.types == nil {
+		return names
+	}
+	importPath := strings.TrimSuffix(p.types.Path(), "_test")
+	for _, obj := range p.info.Defs {
+		if obj == nil {
+			continue
+		}
+		switch obj := obj.(type) {
+		case *types.Var:
+			if !obj.IsField() && obj.Parent() != p.types.Scope() {
+				continue
+			}
+		case *types.Func, *types.TypeName, *types.Const:
+		default:
+			continue
+		}
+		for _, path := range []string{importPath, unvendoredPath(importPath)} {
+			if r.policy.keepIdentifiers.match(path + "." + obj.Name()) {
+				names[obj.Name()] = struct{}{}
+			}
+		}
+	}
+	return names
+}
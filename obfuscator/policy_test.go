@@ -0,0 +1,206 @@
+package obfuscator
+
+import (
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestRules(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"example.com/app", "example.com/app", true},
+		{"example.com/app", "example.com/app/users", false},
+		{"example.com/app/...", "example.com/app", true},
+		{"example.com/app/...", "example.com/app/users/db", true},
+		{"example.com/app/...", "example.com/apps", false},
+		{"example.com/.../db", "example.com/app/users/db", true},
+		{"example.com/*", "example.com/app", true},
+		{"example.com/*", "example.com/app/users", false},
+		{"example.com/ap?", "example.com/app", true},
+		{"example.com/ap?", "example.com/ap/", false},
+		{"example.com/app.Handler", "example.com/appxHandler", false},
+		{"example.com/app.*", "example.com/app.Handler", true},
+		{"*_test.go", "example.com/app/a_test.go", false},
+		{"example.com/app/*_test.go", "example.com/app/a_test.go", true},
+		{"re:example\\.com/(app|api)", "example.com/api", true},
+		{"re:example\\.com/(app|api)", "example.com/api/v2", false},
+		{"re:.*\\.Must[A-Z].*", "example.com/app.MustParse", true},
+	}
+	for _, test := range tests {
+		rs, err := compileRules([]string{test.pattern})
+		if err != nil {
+			t.Fatalf("%s: %v", test.pattern, err)
+		}
+		if got := rs.match(test.name); got != test.want {
+			t.Errorf("%s matching %s = %v, want %v", test.pattern, test.name, got, test.want)
+		}
+	}
+
+	if _, err := compileRules([]string{"re:("}); err == nil {
+		t.Errorf("invalid regular expression compiled")
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	tests := []struct {
+		importPath string
+		options    Options
+		selected   bool
+		keepPath   bool
+		keepFile   bool
+	}{
+		{"example.com/app/users", Options{}, true, false, false},
+		{"example.com/app/users", Options{Include: []string{"example.com/app/..."}}, true, false, false},
+		{"example.com/lib", Options{Include: []string{"example.com/app/..."}}, false, false, false},
+		{"example.com/app/users", Options{Exclude: []string{"example.com/app/users"}}, false, false, false},
+		{
+			"example.com/app/users",
+			Options{Include: []string{"example.com/..."}, Exclude: []string{"example.com/app/..."}},
+			false, false, false,
+		},
+		{"example.com/app/vendor/example.com/lib", Options{Exclude: []string{"example.com/lib"}}, false, false, false},
+		{"example.com/app/users", Options{KeepImportPaths: []string{"example.com/app/*"}}, true, true, true},
+		{"example.com/app/users", Options{KeepFileNames: []string{"example.com/app/users/main.go"}}, true, false, true},
+		{"example.com/app/vendor/example.com/lib", Options{KeepFileNames: []string{"example.com/lib/*.go"}}, true, false, true},
+		{"example.com/app/users", Options{KeepPaths: true}, true, true, true},
+	}
+	for _, test := range tests {
+		r := &rewriter{options: test.options}
+		if err := r.compilePolicy(); err != nil {
+			t.Fatal(err)
+		}
+		pkg := &build.Package{ImportPath: test.importPath}
+		if got := r.selected(pkg); got != test.selected {
+			t.Errorf("%s selected = %v with %+v", test.importPath, got, test.options)
+		}
+		if got := r.keepPath(pkg); got != test.keepPath {
+			t.Errorf("%s keeps path = %v with %+v", test.importPath, got, test.options)
+		}
+		if got := r.keepFileName(pkg, "main.go"); got != test.keepFile {
+			t.Errorf("%s keeps main.go = %v with %+v", test.importPath, got, test.options)
+		}
+	}
+}
+
+func TestKeptIdentifiers(t *testing.T) {
+	const src = `package users
+
+type User struct {
+	Name string
+	id   int
+}
+
+func (u User) MustSave() {}
+
+func MustFind() {
+	var MustLocal int
+	_ = MustLocal
+}
+
+const Limit = 1
+`
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "users.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &loadedPackage{
+		files: []*ast.File{file},
+		info:  &types.Info{Defs: make(map[*ast.Ident]types.Object)},
+	}
+	p.types, err = (&types.Config{}).Check("example.com/app/vendor/example.com/users", fset, p.files, p.info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		rules []string
+		want  []string
+	}{
+		{nil, nil},
+		{[]string{"example.com/users.Must*"}, []string{"MustFind", "MustSave"}},
+		{[]string{"example.com/app/vendor/example.com/users.User"}, []string{"User"}},
+		{[]string{"re:.*\\.(Name|id|Limit)"}, []string{"Limit", "Name", "id"}},
+	}
+	for _, test := range tests {
+		r := &rewriter{options: Options{KeepIdentifiers: test.rules}}
+		if err := r.compilePolicy(); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for name := range r.keptIdentifiers(p) {
+			got = append(got, name)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q kept %q, want %q", test.rules, got, test.want)
+		}
+	}
+}
+
+func TestRewriteKeepImportPaths(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"go.mod": "module example.com/nested\n\ngo 1.16\n",
+		"cmd/app/main.go": `package main
+
+import (
+	"fmt"
+
+	"example.com/nested/lib/sub"
+)
+
+func main() { fmt.Println(sub.Name()) }
+`,
+		"lib/lib.go": `package lib
+
+// Name names the package
+func Name() string { return "lib" }
+`,
+		"lib/lib.txt": "parent data",
+		"lib/sub/sub.go": `package sub
+
+import "example.com/nested/lib"
+
+// Name joins the names of both packages
+func Name() string { return "sub+" + lib.Name() }
+`,
+		"lib/sub/sub.txt": "child data",
+	})
+
+	target := t.TempDir()
+	options := Options{
+		SrcPath:           filepath.Join(root, "cmd", "app"),
+		RootPath:          root,
+		TargetPath:        target,
+		RenameIdentifiers: true,
+		KeepImportPaths:   []string{"example.com/nested/lib/..."},
+		KeepFileNames:     []string{"example.com/nested/lib/..."},
+	}
+	// the child package is reached, and written, before its parent
+	for _, workers := range []int{1, 4} {
+		options.Workers = workers
+		if out := runTarget(t, options); out != "sub+lib\n" {
+			t.Fatalf("%d workers: target printed %q", workers, out)
+		}
+		files := make(map[string]bool)
+		for _, name := range targetFiles(t, target) {
+			files[name] = true
+		}
+		for _, name := range []string{"lib/lib.go", "lib/lib.txt", "lib/sub/sub.go", "lib/sub/sub.txt"} {
+			if !files[name] {
+				t.Errorf("%d workers: %s is missing from the target", workers, name)
+			}
+		}
+	}
+}
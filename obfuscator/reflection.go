@@ -0,0 +1,260 @@
+package obfuscator
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"sort"
+	"strings"
+)
+
+// reflectUse is what a package reads by name from the values passed to it
+type reflectUse int
+
+const (
+	// useFields reads exported fields by name
+	useFields reflectUse = 1 << iota
+	// useMethods calls exported methods by name
+	useMethods
+	// useUnexported reads unexported fields too
+	useUnexported
+)
+
+// reflectSink is a package, and the packages under it, that reads the
+// values passed to its functions through reflection. Fields without a tag
+// under tagKey are read under their own name
+type reflectSink struct {
+	path   string
+	use    reflectUse
+	tagKey string
+}
+
+// reflectSinks are the packages known to read values by name
+var reflectSinks = []reflectSink{
+	{"reflect", useFields | useMethods | useUnexported, ""},
+	{"encoding/json", useFields, "json"},
+	{"encoding/xml", useFields, "xml"},
+	{"encoding/gob", useFields, ""},
+	{"text/template", useFields | useMethods, ""},
+	{"html/template", useFields | useMethods, ""},
+	{"github.com/jinzhu/gorm", useFields | useMethods, ""},
+	{"gorm.io/gorm", useFields | useMethods, ""},
+	{"github.com/go-openapi", useFields | useMethods, ""},
+	{"gopkg.in/yaml.v2", useFields, ""},
+	{"gopkg.in/yaml.v3", useFields, ""},
+	{"github.com/BurntSushi/toml", useFields, ""},
+}
+
+// reflectedField is a field only read under tag keys that leave its name
+// alone when it's untagged, it's given explicit tags instead of keeping
+// its name
+type reflectedField struct {
+	name string
+	keys map[string]struct{}
+}
+
+// collectReflected keeps the names of fields and methods of types whose
+// values the package passes to reflection sinks. Types are followed through
+// pointers, containers and fields, values passed as interfaces first aren't
+// followed
+func (r *rewriter) collectReflected(p *loadedPackage) {
+	if p.info == nil {
+		return
+	}
+	for _, file := range p.files {
+		ast.Inspect(file, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			sink, ok := callSink(p.info, call)
+			if !ok {
+				return true
+			}
+			seen := make(map[types.Type]struct{})
+			for _, arg := range call.Args {
+				if t := p.info.TypeOf(arg); t != nil {
+					r.reflectType(t, sink, false, seen)
+				}
+			}
+			return true
+		})
+	}
+}
+
+// callSink finds the sink the function called belongs to
+func callSink(info *types.Info, call *ast.CallExpr) (reflectSink, bool) {
+	var ident *ast.Ident
+	fun := call.Fun
+	for {
+		paren, ok := fun.(*ast.ParenExpr)
+		if !ok {
+			break
+		}
+		fun = paren.X
+	}
+	switch fun := fun.(type) {
+	case *ast.Ident:
+		ident = fun
+	case *ast.SelectorExpr:
+		ident = fun.Sel
+	default:
+		return reflectSink{}, false
+	}
+	fn, ok := info.Uses[ident].(*types.Func)
+	if !ok || fn.Pkg() == nil {
+		return reflectSink{}, false
+	}
+	path := unvendoredPath(fn.Pkg().Path())
+	for _, sink := range reflectSinks {
+		if path == sink.path || strings.HasPrefix(path, sink.path+"/") {
+			return sink, true
+		}
+	}
+	return reflectSink{}, false
+}
+
+// reflectType keeps the names the sink reads from values of type t. internal
+// is set for the struct types of internal types
+func (r *rewriter) reflectType(t types.Type, sink reflectSink, internal bool, seen map[types.Type]struct{}) {
+	if _, ok := seen[t]; ok {
+		return
+	}
+	seen[t] = struct{}{}
+
+	switch t := types.Unalias(t).(type) {
+	case *types.Named:
+		// only types of packages that are renamed matter
+		if t.Obj().Pkg() == nil {
+			return
+		}
+		if _, ok := r.checkedPackage(t.Obj().Pkg().Path()); !ok {
+			return
+		}
+		if _, ok := t.Underlying().(*types.Interface); !ok && sink.use&useMethods != 0 {
+			methods := types.NewMethodSet(types.NewPointer(t))
+			for i := 0; i < methods.Len(); i++ {
+				if obj := methods.At(i).Obj(); obj.Exported() {
+					r.keep[obj.Name()] = struct{}{}
+				}
+			}
+		}
+		_, internal := r.internalTypes[t.Obj().Pos()]
+		r.reflectType(t.Underlying(), sink, internal, seen)
+	case *types.Pointer:
+		r.reflectType(t.Elem(), sink, false, seen)
+	case *types.Slice:
+		r.reflectType(t.Elem(), sink, false, seen)
+	case *types.Array:
+		r.reflectType(t.Elem(), sink, false, seen)
+	case *types.Map:
+		r.reflectType(t.Key(), sink, false, seen)
+		r.reflectType(t.Elem(), sink, false, seen)
+	case *types.Struct:
+		for i := 0; i < t.NumFields(); i++ {
+			// encoders decode what they encoded in the same process under
+			// the same alias
+			if !internal || sink.use != useFields {
+				r.reflectField(t.Field(i), t.Tag(i), sink)
+			}
+			// anonymous structs belong to the type they're declared in
+			r.reflectType(t.Field(i).Type(), sink, internal, seen)
+		}
+	}
+}
+
+// reflectField keeps the name of a field the sink reads, or with
+// TagReflectedFields tags it if the sink reads untagged fields by a tag key
+// that would name them the same
+func (r *rewriter) reflectField(f *types.Var, tag string, sink reflectSink) {
+	// embedded fields are promoted by their fields rather than read by name
+	if f.Embedded() || f.Pkg() == nil {
+		return
+	}
+	p, ok := r.checkedPackage(f.Pkg().Path())
+	if !ok {
+		return
+	}
+
+	if !f.Exported() {
+		if sink.use&useUnexported != 0 {
+			p.keep[f.Name()] = struct{}{}
+		}
+		return
+	}
+	if sink.use&useFields == 0 {
+		return
+	}
+	if r.options.TagReflectedFields && r.options.RenameExported && r.isInternal(p.build) && sink.tagKey != "" && tag == "" {
+		field, ok := r.reflectedFields[f.Pos()]
+		if !ok {
+			field = &reflectedField{name: f.Name(), keys: make(map[string]struct{})}
+			r.reflectedFields[f.Pos()] = field
+		}
+		field.keys[sink.tagKey] = struct{}{}
+		return
+	}
+	r.keep[f.Name()] = struct{}{}
+}
+
+// tagReflectedFields gives the reflected fields of file explicit tags with
+// their original names. fields declared together are split so each gets
+// its own tag
+func (r *rewriter) tagReflectedFields(job *packageJob, file *ast.File) {
+	if len(r.reflectedFields) == 0 {
+		return
+	}
+	ast.Inspect(file, func(n ast.Node) bool {
+		st, ok := n.(*ast.StructType)
+		if !ok {
+			return true
+		}
+		var list []*ast.Field
+		for _, field := range st.Fields.List {
+			if field.Tag != nil || !r.hasReflectedName(field) {
+				list = append(list, field)
+				continue
+			}
+			for i, name := range field.Names {
+				split := &ast.Field{
+					Names: []*ast.Ident{name},
+					Type:  field.Type,
+				}
+				if i == 0 {
+					split.Doc = field.Doc
+				}
+				if f, ok := r.reflectedFields[name.Pos()]; ok {
+					split.Tag = &ast.BasicLit{Kind: token.STRING, Value: f.tag()}
+					job.tags = append(job.tags, fmt.Sprintf("%s %s", f.name, f.tag()))
+				}
+				list = append(list, split)
+			}
+		}
+		st.Fields.List = list
+		return true
+	})
+}
+
+// hasReflectedName reports whether any name of field is to be tagged
+func (r *rewriter) hasReflectedName(field *ast.Field) bool {
+	for _, name := range field.Names {
+		if _, ok := r.reflectedFields[name.Pos()]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// tag is the struct tag naming the field under every key it's read by
+func (f *reflectedField) tag() string {
+	var keys []string
+	for key := range f.keys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for i, key := range keys {
+		keys[i] = fmt.Sprintf("%s:%q", key, f.name)
+	}
+	return "`" + strings.Join(keys, " ") + "`"
+}
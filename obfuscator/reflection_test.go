@@ -0,0 +1,142 @@
+package obfuscator
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const reflectedSource = `package model
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"text/template"
+)
+
+type Address struct{ City string }
+
+type Place = Address
+
+type Person struct {
+	Name string
+	Age  int
+	Home *Place
+	Tags []string
+}
+
+type Book struct{ Heading string }
+
+func (b Book) Title() string { return strings.ToUpper(b.Heading) }
+
+type Inspected struct {
+	visible int
+	Shown   string
+}
+
+type Secret struct{ Hidden string }
+
+// Render reads values through json, a template and reflect
+func Render() string {
+	data, _ := json.Marshal([]*Person{{Name: "ann", Age: 3, Home: &Place{City: "oslo"}, Tags: []string{"x"}}})
+	var b strings.Builder
+	template.Must(template.New("t").Parse("{{.Title}}")).Execute(&b, Book{Heading: "go"})
+	field := reflect.TypeOf(Inspected{}).Field(0).Name
+	s := Secret{Hidden: "h"}
+	return string(data) + " " + b.String() + " " + field + " " + s.Hidden
+}
+`
+
+const reflectedOutput = `[{"Name":"ann","Age":3,"Home":{"City":"oslo"},"Tags":["x"]}] GO visible h` + "\n"
+
+func rewriteReflected(t *testing.T, tag bool) string {
+	t.Helper()
+	// Place is only seen as an alias when go/types represents aliases
+	t.Setenv("GODEBUG", "gotypesalias=1")
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"go.mod": "module example.com/reflected\n\ngo 1.16\n",
+		"cmd/app/main.go": `package main
+
+import (
+	"fmt"
+
+	"example.com/reflected/model"
+)
+
+func main() { fmt.Print(model.Render(), "\n") }
+`,
+		"model/model.go": reflectedSource,
+	})
+	target := t.TempDir()
+	out := runTarget(t, Options{
+		SrcPath:            filepath.Join(root, "cmd", "app"),
+		RootPath:           root,
+		TargetPath:         target,
+		RenameExported:     true,
+		TagReflectedFields: tag,
+		StripComments:      true,
+	})
+	if out != reflectedOutput {
+		t.Errorf("target printed %q, want %q", out, reflectedOutput)
+	}
+
+	var sources strings.Builder
+	for _, name := range targetFiles(t, target) {
+		data, err := ioutil.ReadFile(filepath.Join(target, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources.Write(data)
+	}
+	return sources.String()
+}
+
+func TestRewriteKeepsReflected(t *testing.T) {
+	sources := rewriteReflected(t, false)
+	tests := []struct {
+		name string
+		kept bool
+	}{
+		{"City string", true},
+		{"Tags []string", true},
+		{"Title()", true},
+		{"visible int", true},
+		{"Shown", true},
+		{"Heading", true},
+		{"Hidden", false},
+		{"Secret", false},
+		{"Render", false},
+	}
+	for _, test := range tests {
+		if kept := strings.Contains(sources, test.name); kept != test.kept {
+			t.Errorf("%s kept = %v, want %v", test.name, kept, test.kept)
+		}
+	}
+}
+
+func TestRewriteTagsReflectedFields(t *testing.T) {
+	sources := rewriteReflected(t, true)
+	for _, tag := range []string{"`json:\"Name\"`", "`json:\"Age\"`", "`json:\"City\"`", "`json:\"Tags\"`"} {
+		if !strings.Contains(sources, tag) {
+			t.Errorf("target has no %s tag", tag)
+		}
+	}
+	tests := []struct {
+		name string
+		kept bool
+	}{
+		{"Name string", false},
+		{"City string", false},
+		{"Heading string", true},
+		{"Title()", true},
+		{"visible int", true},
+	}
+	for _, test := range tests {
+		if kept := strings.Contains(sources, test.name); kept != test.kept {
+			t.Errorf("%s kept = %v, want %v", test.name, kept, test.kept)
+		}
+	}
+}
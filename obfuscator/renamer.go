@@ -0,0 +1,393 @@
+package obfuscator
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// reflectLookups are methods that find fields and methods by name at runtime
+var reflectLookups = map[string]struct{}{
+	"FieldByName":  {},
+	"MethodByName": {},
+}
+
+// wellKnownMethods are checked for by anonymous interface assertions inside
+// library code, which doesn't show up in any package scope
+var wellKnownMethods = []string{
+	"As", "Cause", "Error", "Format", "GoString", "Is", "String", "Timeout",
+	"Temporary", "Unwrap",
+	"MarshalBinary", "MarshalJSON", "MarshalText", "MarshalYAML",
+	"UnmarshalBinary", "UnmarshalJSON", "UnmarshalText", "UnmarshalYAML",
+	"Scan", "Value",
+}
+
+// testFuncPrefixes name the functions go test runs
+var testFuncPrefixes = []string{"Test", "Benchmark", "Example", "Fuzz"}
+
+// renameIdentifiers renames identifiers in a type-checked package. Objects
+// are keyed by the path of the package declaring them and their name so every
+// file, and with RenameExported every importing package, agrees on the alias.
+// Fields and methods are keyed by name alone because struct identity and
+// interface satisfaction depend on matching names rather than matching
+// declarations
+func (r *rewriter) renameIdentifiers(p *loadedPackage) error {
+	for _, file := range p.files {
+		ast.Inspect(file, func(n ast.Node) bool {
+			if ident, ok := n.(*ast.Ident); ok {
+				r.namer.Reserve(ident.Name)
+			}
+			return true
+		})
+	}
+
+	rename := func(ident *ast.Ident, obj types.Object) error {
+		key, ok := r.identifierKey(obj)
+		if !ok {
+			return nil
+		}
+
+		alias, err := r.aliasIdent(key, obj.Exported())
+		if err != nil {
+			return err
+		}
+		ident.Name = alias
+		return nil
+	}
+
+	// visit identifiers in source order so seeded aliases are reproducible.
+	// files are parsed concurrently so they're ordered as the package lists
+	// them rather than by where the file set placed them
+	var idents []*ast.Ident
+	for ident, obj := range p.info.Defs {
+		if obj != nil {
+			idents = append(idents, ident)
+		}
+	}
+	for ident := range p.info.Uses {
+		idents = append(idents, ident)
+	}
+	fileIndex := make(map[*token.File]int, len(p.files))
+	for i, file := range p.files {
+		fileIndex[r.fset.File(file.Pos())] = i
+	}
+	order := make(map[*ast.Ident]int, len(idents))
+	for _, ident := range idents {
+		order[ident] = fileIndex[r.fset.File(ident.Pos())]
+	}
+	sort.Slice(idents, func(i, j int) bool {
+		if order[idents[i]] != order[idents[j]] {
+			return order[idents[i]] < order[idents[j]]
+		}
+		return idents[i].Pos() < idents[j].Pos()
+	})
+
+	for _, ident := range idents {
+		obj, ok := p.info.Defs[ident]
+		if !ok || obj == nil {
+			obj = p.info.Uses[ident]
+		}
+		if err := rename(ident, obj); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// identifierKey names the namer key for obj, or false if obj must keep its
+// original name
+func (r *rewriter) identifierKey(obj types.Object) (string, bool) {
+	key, ok := r.objectKey(obj)
+	if !ok {
+		return "", false
+	}
+	if _, ok := r.keepKeys[key]; ok {
+		return "", false
+	}
+	return key, true
+}
+
+// objectKey is the key obj is renamed by unless a directive keeps it, or
+// false if it's never renamed
+func (r *rewriter) objectKey(obj types.Object) (string, bool) {
+	obj = originObject(obj)
+	if obj.Pkg() == nil || obj.Name() == "_" {
+		return "", false
+	}
+
+	p, ok := r.checkedPackage(obj.Pkg().Path())
+	if !ok || p.opaque || !r.selected(p.build) {
+		return "", false
+	}
+
+	if obj.Exported() {
+		if !r.options.RenameExported || !r.isInternal(p.build) {
+			return "", false
+		}
+		if _, ok := r.keep[obj.Name()]; ok {
+			return "", false
+		}
+	} else if _, ok := p.keep[obj.Name()]; ok {
+		return "", false
+	}
+
+	path := p.types.Path()
+	switch obj := obj.(type) {
+	case *types.Var:
+		if obj.IsField() {
+			// embedded fields are named after their type
+			if obj.Embedded() {
+				named, ok := derefType(obj.Type()).(*types.Named)
+				if !ok {
+					return "", false
+				}
+				return r.identifierKey(named.Obj())
+			}
+			if obj.Exported() {
+				return "field:" + obj.Name(), true
+			}
+			return path + ".field:" + obj.Name(), true
+		}
+	case *types.Func:
+		if sig, ok := obj.Type().(*types.Signature); ok && sig.Recv() != nil {
+			if obj.Exported() {
+				if _, ok := r.externalMethods[obj.Name()]; ok {
+					return "", false
+				}
+				return "method:" + obj.Name(), true
+			}
+			return path + ".method:" + obj.Name(), true
+		}
+		if obj.Name() == "init" || (obj.Name() == "main" && p.types.Name() == "main") {
+			return "", false
+		}
+		if r.isTestFunc(obj) {
+			return "", false
+		}
+		if r.isBodyless(obj) {
+			return "", false
+		}
+	case *types.TypeName, *types.Const:
+	default:
+		return "", false
+	}
+
+	// locals don't reach the symbol table. the object may come from the
+	// test variant of the package so its own scope is checked
+	if obj.Parent() != obj.Pkg().Scope() {
+		return "", false
+	}
+	return path + "." + obj.Name(), true
+}
+
+// checkedPackage finds the package with the import path as it's checked for
+// the first platform that builds it. keys and keep lists are the same for
+// every platform so any of them will do
+func (r *rewriter) checkedPackage(path string) (*loadedPackage, bool) {
+	for _, pl := range r.platforms {
+		if p, ok := pl.checked[path]; ok {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// aliasIdent aliases an identifier key and records it in the mapping
+func (r *rewriter) aliasIdent(key string, exported bool) (string, error) {
+	alias, err := r.namer.AliasIdent(key, exported)
+	if err != nil {
+		return "", err
+	}
+	r.mapping.Identifiers[key] = alias
+	if r.current != nil {
+		r.current.aliases[key] = alias
+	}
+	return alias, nil
+}
+
+// identName is the name obj is referred to by in rewritten code
+func (r *rewriter) identName(obj types.Object) string {
+	if key, ok := r.identifierKey(obj); ok {
+		if alias, ok := r.namer.Lookup(key); ok {
+			if r.current != nil {
+				r.current.aliases[key] = alias
+			}
+			return alias
+		}
+	}
+	return obj.Name()
+}
+
+// collectExternalMethods records the method names of every interface declared
+// by packages reachable from the checked packages whose exported names won't
+// be renamed.
+// Methods with these names may be needed to satisfy those interfaces and keep
+// their names
+func (r *rewriter) collectExternalMethods() {
+	r.externalMethods = make(map[string]struct{})
+	for _, name := range wellKnownMethods {
+		r.externalMethods[name] = struct{}{}
+	}
+
+	seen := make(map[*types.Package]struct{})
+	var walk func(pkg *types.Package)
+	walk = func(pkg *types.Package) {
+		if _, ok := seen[pkg]; ok {
+			return
+		}
+		seen[pkg] = struct{}{}
+
+		if p, ok := r.checkedPackage(pkg.Path()); !ok || !r.isInternal(p.build) || !r.selected(p.build) {
+			scope := pkg.Scope()
+			for _, name := range scope.Names() {
+				tn, ok := scope.Lookup(name).(*types.TypeName)
+				if !ok {
+					continue
+				}
+				iface, ok := tn.Type().Underlying().(*types.Interface)
+				if !ok {
+					continue
+				}
+				for i := 0; i < iface.NumMethods(); i++ {
+					r.externalMethods[iface.Method(i).Name()] = struct{}{}
+				}
+			}
+		}
+
+		for _, imp := range pkg.Imports() {
+			walk(imp)
+		}
+	}
+	for _, pl := range r.platforms {
+		for _, p := range pl.checked {
+			for _, variant := range []*loadedPackage{p, p.test, p.xtest} {
+				if variant != nil && variant.types != nil {
+					walk(variant.types)
+				}
+			}
+		}
+	}
+}
+
+// isTestFunc reports whether fn is a test, benchmark, example or fuzz target
+// that go test finds by name
+func (r *rewriter) isTestFunc(fn *types.Func) bool {
+	if !strings.HasSuffix(r.fset.File(fn.Pos()).Name(), "_test.go") {
+		return false
+	}
+	for _, prefix := range testFuncPrefixes {
+		if strings.HasPrefix(fn.Name(), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// originObject maps instantiated generic fields and functions back to their
+// declarations
+func originObject(obj types.Object) types.Object {
+	switch obj := obj.(type) {
+	case *types.Var:
+		return obj.Origin()
+	case *types.Func:
+		return obj.Origin()
+	}
+	return obj
+}
+
+func derefType(t types.Type) types.Type {
+	if ptr, ok := t.(*types.Pointer); ok {
+		return ptr.Elem()
+	}
+	return t
+}
+
+// taggedFieldNames collects the names of struct fields that carry a tag,
+// tagged fields are assumed to be read by name through reflection. Fields
+// of internal types are only read inside the process and are left out
+func (r *rewriter) taggedFieldNames(p *loadedPackage) map[string]struct{} {
+	names := make(map[string]struct{})
+	for _, file := range p.files {
+		ast.Inspect(file, func(n ast.Node) bool {
+			if spec, ok := n.(*ast.TypeSpec); ok {
+				_, internal := r.internalTypes[spec.Name.Pos()]
+				return !internal
+			}
+			field, ok := n.(*ast.Field)
+			if !ok || field.Tag == nil {
+				return true
+			}
+			for _, name := range field.Names {
+				names[name.Name] = struct{}{}
+			}
+			return true
+		})
+	}
+	return names
+}
+
+// isBodyless reports whether fn, or the function of the same name on
+// another platform, is declared without a body, i.e. implemented in assembly
+// or linked in by name. Every platform has to agree on the name
+func (r *rewriter) isBodyless(fn *types.Func) bool {
+	if r.hasNoBody(fn) {
+		return true
+	}
+	for _, pl := range r.platforms {
+		if p, ok := pl.checked[fn.Pkg().Path()]; ok {
+			other, ok := p.types.Scope().Lookup(fn.Name()).(*types.Func)
+			if ok && r.hasNoBody(other) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r *rewriter) hasNoBody(fn *types.Func) bool {
+	file, ok := r.parsed[r.fset.File(fn.Pos()).Name()]
+	if !ok {
+		return false
+	}
+	for _, decl := range file.Decls {
+		fd, ok := decl.(*ast.FuncDecl)
+		if ok && fd.Recv == nil && fd.Name.Pos() == fn.Pos() {
+			return fd.Body == nil
+		}
+	}
+	return false
+}
+
+// reflectedNames collects string constants passed to reflect lookups such as
+// FieldByName so the identifiers they name are left alone
+func reflectedNames(p *loadedPackage) map[string]struct{} {
+	names := make(map[string]struct{})
+	for _, file := range p.files {
+		ast.Inspect(file, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) != 1 {
+				return true
+			}
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			if _, ok := reflectLookups[sel.Sel.Name]; !ok {
+				return true
+			}
+			lit, ok := call.Args[0].(*ast.BasicLit)
+			if !ok || lit.Kind != token.STRING {
+				return true
+			}
+			if name, err := strconv.Unquote(lit.Value); err == nil {
+				names[name] = struct{}{}
+			}
+			return true
+		})
+	}
+	return names
+}
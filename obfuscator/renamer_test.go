@@ -0,0 +1,398 @@
+package obfuscator
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const keysSource = `package keys
+
+type counter struct {
+	count int
+	Total int
+}
+
+func (c *counter) incr() { c.count++ }
+
+func (c *counter) Reset() { c.count = 0 }
+
+type wrapper struct {
+	counter
+}
+
+func helper() int {
+	local := 1
+	return local
+}
+
+func Helper() {}
+
+const limit = 3
+
+var total int
+
+func init() {}
+
+func now() int64
+`
+
+// checkSource parses and type-checks src as the package at path
+func checkSource(t *testing.T, fset *token.FileSet, path, src string, imp types.Importer) *loadedPackage {
+	t.Helper()
+	file, err := parser.ParseFile(fset, filepath.Base(path)+".go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &loadedPackage{
+		files: []*ast.File{file},
+		info: &types.Info{
+			Defs: make(map[*ast.Ident]types.Object),
+			Uses: make(map[*ast.Ident]types.Object),
+		},
+	}
+	p.types, err = (&types.Config{Importer: imp}).Check(path, fset, p.files, p.info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// packageImporter imports packages checked by the test
+type packageImporter map[string]*types.Package
+
+func (imp packageImporter) Import(path string) (*types.Package, error) {
+	if pkg, ok := imp[path]; ok {
+		return pkg, nil
+	}
+	return nil, fmt.Errorf("%s not checked", path)
+}
+
+// internalRewriter is a rewriter that has checked the packages in fset,
+// those under /src as internal ones
+func internalRewriter(fset *token.FileSet, options Options, pkgs ...*loadedPackage) *rewriter {
+	options.RootPath = "/src"
+	r := &rewriter{
+		options: options,
+		fset:    fset,
+		parsed:  make(map[string]*ast.File),
+		keep:    make(map[string]struct{}),
+	}
+	pl := &platform{checked: make(map[string]*loadedPackage)}
+	for _, p := range pkgs {
+		pl.checked[p.types.Path()] = p
+		for _, file := range p.files {
+			r.parsed[fset.File(file.Pos()).Name()] = file
+		}
+	}
+	r.platforms = []*platform{pl}
+	return r
+}
+
+// lookupObject finds a package level object by name, a field or method by
+// type.name or a local by its name
+func lookupObject(t *testing.T, p *loadedPackage, name string) types.Object {
+	t.Helper()
+	if i := strings.Index(name, "."); i != -1 {
+		obj, _, _ := types.LookupFieldOrMethod(p.types.Scope().Lookup(name[:i]).Type(), true, p.types, name[i+1:])
+		if obj == nil {
+			t.Fatalf("%s not found", name)
+		}
+		return obj
+	}
+	if obj := p.types.Scope().Lookup(name); obj != nil {
+		return obj
+	}
+	for ident, obj := range p.info.Defs {
+		if obj != nil && ident.Name == name {
+			return obj
+		}
+	}
+	t.Fatalf("%s not found", name)
+	return nil
+}
+
+func TestIdentifierKeys(t *testing.T) {
+	fset := token.NewFileSet()
+	p := checkSource(t, fset, "example.com/keys", keysSource, nil)
+	p.build = &build.Package{ImportPath: "example.com/keys", Dir: "/src/keys"}
+	p.keep = make(map[string]struct{})
+	tests := []struct {
+		name     string
+		key      string
+		exported string
+	}{
+		{"counter", "example.com/keys.counter", "example.com/keys.counter"},
+		{"counter.count", "example.com/keys.field:count", "example.com/keys.field:count"},
+		{"counter.Total", "", "field:Total"},
+		{"counter.incr", "example.com/keys.method:incr", "example.com/keys.method:incr"},
+		{"counter.Reset", "", "method:Reset"},
+		{"wrapper", "example.com/keys.wrapper", "example.com/keys.wrapper"},
+		{"wrapper.counter", "example.com/keys.counter", "example.com/keys.counter"},
+		{"helper", "example.com/keys.helper", "example.com/keys.helper"},
+		{"Helper", "", "example.com/keys.Helper"},
+		{"local", "", ""},
+		{"limit", "example.com/keys.limit", "example.com/keys.limit"},
+		{"total", "example.com/keys.total", "example.com/keys.total"},
+		{"init", "", ""},
+		{"now", "", ""},
+	}
+	for _, exported := range []bool{false, true} {
+		r := internalRewriter(fset, Options{RenameExported: exported}, p)
+		for _, test := range tests {
+			want := test.key
+			if exported {
+				want = test.exported
+			}
+			key, ok := r.identifierKey(lookupObject(t, p, test.name))
+			if key != want || ok != (want != "") {
+				t.Errorf("%s keyed %q, %v with RenameExported %v, want %q", test.name, key, ok, exported, want)
+			}
+		}
+	}
+
+	// packages outside of the root keep their exported names
+	p.build.Dir = "/lib/keys"
+	r := internalRewriter(fset, Options{RenameExported: true}, p)
+	if key, ok := r.identifierKey(lookupObject(t, p, "Helper")); ok {
+		t.Errorf("Helper outside of the root keyed %q", key)
+	}
+}
+
+func TestExternalMethods(t *testing.T) {
+	fset := token.NewFileSet()
+	ext := checkSource(t, fset, "example.com/ext", `package ext
+
+type Runner interface {
+	Run()
+}
+`, nil)
+	ext.build = &build.Package{ImportPath: "example.com/ext", Dir: "/lib/ext"}
+	app := checkSource(t, fset, "example.com/app", `package app
+
+import "example.com/ext"
+
+type local interface {
+	Local()
+}
+
+type job struct{}
+
+func (job) Run()   {}
+func (job) stop()  {}
+func (job) Local() {}
+func (job) Other() {}
+func (job) Error() string { return "" }
+
+var _ ext.Runner = job{}
+var _ local = job{}
+`, packageImporter{"example.com/ext": ext.types})
+	app.build = &build.Package{ImportPath: "example.com/app", Dir: "/src/app"}
+	app.keep = make(map[string]struct{})
+
+	r := internalRewriter(fset, Options{RenameExported: true}, ext, app)
+	r.collectExternalMethods()
+	tests := []struct {
+		name string
+		key  string
+	}{
+		{"job.Run", ""},
+		{"job.Error", ""},
+		{"job.stop", "example.com/app.method:stop"},
+		{"job.Local", "method:Local"},
+		{"job.Other", "method:Other"},
+	}
+	for _, test := range tests {
+		key, ok := r.identifierKey(lookupObject(t, app, test.name))
+		if key != test.key || ok != (test.key != "") {
+			t.Errorf("%s keyed %q, %v, want %q", test.name, key, ok, test.key)
+		}
+	}
+}
+
+func TestRewriteRenamesIdentifiers(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"go.mod": "module example.com/names\n\ngo 1.16\n",
+		"cmd/app/main.go": `package main
+
+import (
+	"fmt"
+
+	"example.com/names/store"
+)
+
+func main() { fmt.Println(store.Run()) }
+`,
+		"store/store.go": `package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+type shelfItem struct {
+	itemName  string
+	Quantity  int    ` + "`json:\"quantity\"`" + `
+	shelfCode string
+}
+
+type shelfCounter interface {
+	countShelves() int
+}
+
+func (s shelfItem) countShelves() int { return s.Quantity }
+
+// String makes shelfItem a fmt.Stringer
+func (s shelfItem) String() string { return s.itemName + "@" + s.shelfCode }
+
+const shelfLimit = 2
+
+func Run() string {
+	var c shelfCounter = shelfItem{itemName: "apple", Quantity: shelfLimit, shelfCode: "a1"}
+	data, _ := json.Marshal(c)
+	field, _ := reflect.TypeOf(c).FieldByName("shelfCode")
+	return fmt.Sprint(c, " ", c.countShelves(), " ", string(data), " ", field.Name)
+}
+`,
+	})
+
+	target := t.TempDir()
+	out := runTarget(t, Options{
+		SrcPath:           filepath.Join(root, "cmd", "app"),
+		RootPath:          root,
+		TargetPath:        target,
+		RenameIdentifiers: true,
+		StripComments:     true,
+	})
+	if want := "apple@a1 2 {\"quantity\":2} shelfCode\n"; out != want {
+		t.Errorf("target printed %q, want %q", out, want)
+	}
+
+	var sources strings.Builder
+	for _, name := range targetFiles(t, target) {
+		if strings.HasSuffix(name, ".go") {
+			data, err := ioutil.ReadFile(filepath.Join(target, filepath.FromSlash(name)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			sources.Write(data)
+		}
+	}
+	tests := []struct {
+		name string
+		kept bool
+	}{
+		{"shelfItem", false},
+		{"itemName", false},
+		{"shelfCounter", false},
+		{"countShelves", false},
+		{"shelfLimit", false},
+		{"Quantity", true},
+		{"String", true},
+		{"Run", true},
+		{"shelfCode", true},
+	}
+	for _, test := range tests {
+		if kept := strings.Contains(sources.String(), test.name); kept != test.kept {
+			t.Errorf("%s kept = %v, want %v", test.name, kept, test.kept)
+		}
+	}
+}
+
+func TestRewriteRenamesExported(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"app/go.mod": "module example.com/app\n\ngo 1.16\n\nrequire example.com/lib v1.0.0\n\nreplace example.com/lib => ../lib\n",
+		"app/cmd/app/main.go": `package main
+
+import (
+	"fmt"
+
+	"example.com/app/accounts"
+)
+
+func main() {
+	svc := accounts.NewUserService()
+	fmt.Println(svc.CreateAccount("alice"), svc)
+}
+`,
+		"app/accounts/accounts.go": `package accounts
+
+import "example.com/lib/names"
+
+// UserService creates accounts
+type UserService struct {
+	Created []string
+}
+
+// NewUserService makes a UserService
+func NewUserService() *UserService { return &UserService{} }
+
+// CreateAccount creates an account
+func (s *UserService) CreateAccount(name string) string {
+	s.Created = append(s.Created, names.Normalize(name))
+	return s.Created[0]
+}
+
+// String describes the service
+func (s *UserService) String() string { return "service" }
+`,
+		"lib/go.mod": "module example.com/lib\n\ngo 1.16\n",
+		"lib/names/names.go": `package names
+
+import "strings"
+
+// Normalize normalizes a name
+func Normalize(name string) string { return strings.ToUpper(name) }
+`,
+	})
+
+	target := t.TempDir()
+	out := runTarget(t, Options{
+		SrcPath:           filepath.Join(root, "app", "cmd", "app"),
+		RootPath:          filepath.Join(root, "app"),
+		TargetPath:        target,
+		RenameIdentifiers: true,
+		RenameExported:    true,
+		StripComments:     true,
+	})
+	if out != "ALICE service\n" {
+		t.Errorf("target printed %q", out)
+	}
+
+	var sources strings.Builder
+	for _, name := range targetFiles(t, target) {
+		if strings.HasSuffix(name, ".go") {
+			data, err := ioutil.ReadFile(filepath.Join(target, filepath.FromSlash(name)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			sources.Write(data)
+		}
+	}
+	tests := []struct {
+		name string
+		kept bool
+	}{
+		{"UserService", false},
+		{"NewUserService", false},
+		{"CreateAccount", false},
+		{"Created", false},
+		{"String", true},
+		{"Normalize", true},
+	}
+	for _, test := range tests {
+		if kept := strings.Contains(sources.String(), test.name); kept != test.kept {
+			t.Errorf("%s kept = %v, want %v", test.name, kept, test.kept)
+		}
+	}
+}
@@ -4,20 +4,17 @@ import (
 	"fmt"
 	"go/ast"
 	"go/build"
-	"go/format"
-	"go/parser"
 	"go/token"
-	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
-
-	"github.com/otiai10/copy"
 )
 
 const (
 	vendorPath = "/vendor/"
+
+	defaultAliasLength = 5
 )
 
 // Options ...
@@ -25,34 +22,331 @@ type Options struct {
 	SrcPath    string
 	RootPath   string
 	TargetPath string
+
+	// RenameIdentifiers renames unexported identifiers
+	RenameIdentifiers bool
+	// RenameExported also renames exported identifiers of packages under RootPath
+	RenameExported bool
+	// EncryptStrings replaces string literals with calls to generated decoders
+	EncryptStrings bool
+	// LineDirectives emits //line directives pointing at the original lines
+	LineDirectives bool
+	// Tests rewrites the test files of packages under RootPath too
+	Tests bool
+	// TagReflectedFields tags fields only encoding/json and encoding/xml read by name
+	TagReflectedFields bool
+	// InternalTypes are rules matching types whose values never leave the process
+	InternalTypes []string
+	// TagPolicy is applied to the struct tags of internal types
+	TagPolicy TagPolicy
+	// TagReportPath is where the tags changed are written as JSON
+	TagReportPath string
+	// Flatten are rules matching functions whose control flow is flattened
+	Flatten []string
+	// KeepPaths keeps the original import paths and file names of packages
+	KeepPaths bool
+	// StripComments drops comments from rewritten files but for directives,
+	// build constraints and cgo preambles
+	StripComments bool
+
+	// Include are rules matching the packages the passes run over, all if empty
+	Include []string
+	// Exclude are rules matching packages the passes leave alone
+	Exclude []string
+
+	// KeepImportPaths are rules matching packages that keep their import paths
+	KeepImportPaths []string
+	// KeepFileNames are rules matching go files, by import path and name, that keep their names
+	KeepFileNames []string
+	// KeepIdentifiers are rules matching identifiers, by import path and name, that keep their names
+	KeepIdentifiers []string
+
+	// AliasLength is the length of generated aliases, 5 if it's zero
+	AliasLength int
+
+	// Platforms are the GOOS/GOARCH pairs the target builds for, the host if empty
+	Platforms []string
+	// Tags are the comma separated tag sets the target is built with
+	Tags []string
+
+	// Seed derives aliases and keys from the original names for reproducible builds
+	Seed string
+
+	// MappingPath is where the alias mapping is written, nowhere if it's empty
+	MappingPath string
+	// PreviousMappingPath is a mapping of an earlier build whose aliases are reused
+	PreviousMappingPath string
+
+	// DryRun aliases the project without writing anything, see PlanRewrite
+	DryRun bool
+
+	// CachePath is where hashes of written packages are kept to skip unchanged ones
+	CachePath string
+
+	// Workers is the number of packages parsed and written concurrently, NumCPU if zero
+	Workers int
+
+	// CollectErrors keeps rewriting after a package fails and returns every failure
+	CollectErrors bool
 }
 
 // Rewrite target project
 func Rewrite(options Options) (string, error) {
-	context := build.Default
-
-	pkg, err := context.ImportDir(options.SrcPath, 0)
+	r, err := rewrite(options)
 	if err != nil {
 		return "", err
 	}
+	return r.target, nil
+}
+
+func rewrite(options Options) (*rewriter, error) {
+	if options.RenameExported {
+		options.RenameIdentifiers = true
+	}
 
 	r := &rewriter{
-		options: options,
-		namer:   NewNamer(5),
-		seen:    make(map[string]struct{}),
+		options:   options,
+		context:   build.Default,
+		namer:     NewNamer(aliasLength(options)),
+		seen:      make(map[string]struct{}),
+		fset:      token.NewFileSet(),
+		parsed:    make(map[string]*ast.File),
+		keepNames: make(map[string]map[string]struct{}),
+		keep:      make(map[string]struct{}),
+		mapping:   NewMapping(),
+		planned:   make(map[string]*PlannedPackage),
+		copies:    make(map[string]map[string]struct{}),
+
+		keptModules:      make(map[string]struct{}),
+		reflectedFields:  make(map[token.Pos]*reflectedField),
+		internalTypes:    make(map[token.Pos]struct{}),
+		keepKeys:         make(map[string]struct{}),
+		stringDirectives: make(map[token.Pos]string),
+		flattenTargets:   make(map[*ast.FuncDecl]*flattenTarget),
+	}
+	r.mapping.LineDirectives = options.LineDirectives
+	r.plan = &Plan{Mapping: r.mapping}
+
+	if options.AliasLength < 0 {
+		return nil, r.fail(OptionsError, "", ErrAliasLength)
+	}
+	if err := r.compilePolicy(); err != nil {
+		return nil, err
+	}
+
+	if options.Seed != "" {
+		r.namer = NewSeededNamer(aliasLength(options), options.Seed)
 	}
-	alias, err := r.RewritePackage(pkg)
+
+	if options.CachePath != "" {
+		var err error
+		r.cache, err = loadCache(options.CachePath)
+		if err != nil {
+			return nil, r.fail(OptionsError, options.CachePath, err)
+		}
+		if options.PreviousMappingPath == "" && options.MappingPath != "" {
+			if _, err := os.Stat(options.MappingPath); err == nil {
+				options.PreviousMappingPath = options.MappingPath
+			}
+		}
+	}
+
+	if options.PreviousMappingPath != "" {
+		previous, err := LoadMapping(options.PreviousMappingPath)
+		if err != nil {
+			return nil, r.fail(OptionsError, options.PreviousMappingPath, err)
+		}
+		previous.Apply(r.namer)
+	}
+
+	if UsesModules(options) {
+		var err error
+		r.modules, err = newModuleGraph(options.RootPath)
+		if err != nil {
+			return nil, r.fail(ResolveError, options.RootPath, err)
+		}
+		// the go command locates the main module from the working directory
+		r.context.Dir = r.modules.main.Dir
+	}
+
+	if err := r.newPlatforms(); err != nil {
+		return nil, err
+	}
+
+	pkg, err := r.importDir(options.SrcPath)
 	if err != nil {
-		return "", err
+		return nil, r.fail(ResolveError, options.SrcPath, err)
 	}
+	pkgs := r.discoverPackages(pkg)
+	r.findKeptModules(pkgs)
+	r.parsePackages(pkgs)
 
-	return alias, nil
+	// sources may ask for their strings to be encrypted without the option
+	if options.EncryptStrings || hasEncryptDirective(r.parsedFiles(pkgs)) {
+		if r.cache != nil && r.cache.StringKey != nil && options.Seed == "" {
+			r.stringKey = r.cache.StringKey
+		} else {
+			r.stringKey, err = newStringKey(r.namer)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if r.cache != nil && options.Seed == "" {
+			r.cache.StringKey = r.stringKey
+		}
+	}
+
+	// exported names are shared across packages so the whole graph, tests
+	// included, has to be checked before the first one is renamed
+	if options.RenameExported {
+		for _, pl := range r.platforms {
+			if _, err := pl.variant(pkg); err != nil {
+				if err := r.collect(r.fail(CheckError, pkg.Dir, err)); err != nil {
+					return nil, err
+				}
+			}
+			if options.Tests {
+				if err := pl.checkAllTests(); err != nil {
+					if err := r.collect(r.fail(CheckError, pkg.Dir, err)); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+		r.collectExternalMethods()
+	}
+
+	if _, err := r.RewritePackage(pkg); err != nil {
+		if err := r.collect(err); err != nil {
+			return nil, err
+		}
+	}
+
+	if !options.DryRun {
+		if err := r.writePackages(); err != nil {
+			return nil, err
+		}
+	}
+
+	if r.modules != nil {
+		if err := r.writeModules(); err != nil {
+			return nil, r.fail(WriteError, options.TargetPath, err)
+		}
+	}
+
+	if options.TagReportPath != "" && !options.DryRun {
+		if err := writeTagReport(options.TagReportPath, r.plan.Tags); err != nil {
+			return nil, r.fail(WriteError, options.TagReportPath, err)
+		}
+	}
+
+	if options.MappingPath != "" && !options.DryRun {
+		if err := r.mapping.Save(options.MappingPath); err != nil {
+			return nil, r.fail(WriteError, options.MappingPath, err)
+		}
+	}
+
+	if len(r.errs) > 0 {
+		return nil, r.errs
+	}
+
+	r.target, err = r.targetImportPath(pkg)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// collect records err and returns nil if errors are collected, otherwise it
+// returns err so the rewrite stops
+func (r *rewriter) collect(err error) error {
+	if err == nil || !r.options.CollectErrors {
+		return err
+	}
+	// a failed package is reported by everything that imports it
+	for _, e := range r.errs {
+		if e.Error() == err.Error() {
+			return nil
+		}
+	}
+	r.errs = append(r.errs, err)
+	return nil
+}
+
+// checkAllTests checks the tests of every internal package checked so far
+// for the platform, including packages only the tests import
+func (pl *platform) checkAllTests() error {
+	r := pl.rewriter
+	done := make(map[*loadedPackage]struct{})
+	for {
+		var pending []*loadedPackage
+		for _, p := range pl.checked {
+			if _, ok := done[p]; !ok && r.isInternal(p.build) {
+				pending = append(pending, p)
+			}
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+		for _, p := range pending {
+			done[p] = struct{}{}
+			if err := pl.checkTests(p); err != nil {
+				return err
+			}
+		}
+	}
 }
 
 type rewriter struct {
 	options Options
+	context build.Context
 	namer   *Namer
 	seen    map[string]struct{}
+	modules *moduleGraph
+
+	platforms []*platform
+	fset      *token.FileSet
+	parsed    map[string]*ast.File
+
+	keepNames       map[string]map[string]struct{}
+	keep            map[string]struct{}
+	externalMethods map[string]struct{}
+	stringKey       []byte
+	// reflectedFields are the fields to tag by the position of their names
+	reflectedFields map[token.Pos]*reflectedField
+	// internalTypes are the internal types checked by the position of their
+	// names
+	internalTypes map[token.Pos]struct{}
+	// keepKeys are the keys of identifiers kept by directives
+	keepKeys map[string]struct{}
+	// stringDirectives are the string directives of expressions by their
+	// positions
+	stringDirectives map[token.Pos]string
+	// flattenTargets are the functions to flatten, read before they're
+	// renamed
+	flattenTargets map[*ast.FuncDecl]*flattenTarget
+
+	// copies are the paths under package directories that their own or
+	// other packages' sources include, by package directory
+	copies map[string]map[string]struct{}
+
+	policy      policy
+	keptModules map[string]struct{}
+
+	mapping *Mapping
+	plan    *Plan
+	planned map[string]*PlannedPackage
+	jobs    []*packageJob
+	cache   *Cache
+	// current is the job being renamed, it records the aliases it uses
+	current *packageJob
+	target  string
+	tested  []string
+
+	// chain holds the import paths of the packages being rewritten or
+	// checked, outermost first, errors report it as their import chain
+	chain []string
+	errs  Errors
 }
 
 func (r *rewriter) RewritePackage(pkg *build.Package) (string, error) {
@@ -61,94 +355,305 @@ func (r *rewriter) RewritePackage(pkg *build.Package) (string, error) {
 	}
 	r.seen[pkg.Dir] = struct{}{}
 
-	if strings.HasPrefix(pkg.Dir, os.Getenv("GOROOT")) {
+	if pkg.Goroot {
 		r.namer.Assign(pkg.ImportPath, pkg.ImportPath)
 		r.namer.Assign(pkg.Dir, pkg.Dir)
 		return pkg.Dir, nil
 	}
 
+	if err := r.resolveImportPath(pkg); err != nil {
+		return "", r.fail(ResolveError, pkg.Dir, err)
+	}
+	r.chain = append(r.chain, pkg.ImportPath)
+	defer func() { r.chain = r.chain[:len(r.chain)-1] }()
+
 	names := []string{pkg.ImportPath, pkg.Dir}
 	vendorPathIndex := strings.LastIndex(pkg.Dir, vendorPath)
 	if vendorPathIndex != -1 {
 		names = append(names, pkg.Dir[vendorPathIndex+len(vendorPath):])
 	}
 
-	alias, err := r.namer.AliasAll(names)
+	keep := r.keepPath(pkg)
+	var alias string
+	var err error
+	if keep {
+		alias, err = r.keepPackagePath(pkg, names)
+	} else {
+		alias, err = r.namer.AliasAll(names)
+	}
+	if err != nil {
+		return "", err
+	}
+	dir, err := r.targetDir(pkg)
+	if err != nil {
+		return "", err
+	}
+	importPath, err := r.targetImportPath(pkg)
 	if err != nil {
 		return "", err
 	}
-	copy.Copy(pkg.Dir, path.Join(r.options.TargetPath, "src", alias))
+	// kept paths aren't aliases, the mapping has nothing to recover
+	if !keep {
+		r.mapping.Directories[pkg.Dir] = alias
+		for _, name := range names {
+			if name != pkg.Dir {
+				r.mapping.ImportPaths[name] = importPath
+			}
+		}
+	}
 
-	var paths []string
-	paths = append(paths, pkg.GoFiles...)
-	prefixDirectory(pkg.Dir, paths)
+	planned := &PlannedPackage{
+		ImportPath:       pkg.ImportPath,
+		Dir:              pkg.Dir,
+		TargetImportPath: importPath,
+		TargetDir:        dir,
+	}
+	r.plan.Packages = append(r.plan.Packages, planned)
+	r.planned[pkg.Dir] = planned
+
+	job := &packageJob{
+		pkg:     pkg,
+		dir:     dir,
+		tests:   r.options.Tests && r.isInternal(pkg),
+		chain:   r.importChain(),
+		aliases: make(map[string]string),
+	}
+	r.jobs = append(r.jobs, job)
 
-	for _, path := range paths {
-		err := r.rewriteFile(pkg, path)
+	paths, files, err := r.parseGoFiles(pkg)
+	if err != nil {
+		return "", err
+	}
+	var testPaths []string
+	var testFiles []*ast.File
+	if job.tests {
+		testPaths, testFiles, err = r.parseTestFiles(pkg)
 		if err != nil {
 			return "", err
 		}
 	}
 
+	allFiles := append(append([]*ast.File{}, files...), testFiles...)
+	if err := r.collect(r.checkDirectives(allFiles)); err != nil {
+		return "", err
+	}
+	// tags are read before their types and fields are renamed
+	if err := r.collect(r.fail(ParseError, pkg.Dir, r.rewriteTags(job, allFiles))); err != nil {
+		return "", err
+	}
+
+	// a package that can't be renamed is still written with its imports
+	// aliased so the rest of the graph is rewritten when collecting errors
+	if r.options.RenameIdentifiers || r.options.EncryptStrings || hasEncryptDirective(allFiles) ||
+		r.hasFlattenTargets(pkg, allFiles) {
+		if err := r.collect(r.renamePackage(job)); err != nil {
+			return "", err
+		}
+	}
+	if err := r.rewriteSources(job, files[len(pkg.GoFiles):]); err != nil {
+		if err := r.collect(r.fail(WriteError, dir, err)); err != nil {
+			return "", err
+		}
+	}
+	for i := range files {
+		if err := r.collect(r.rewriteFile(job, paths[i], files[i])); err != nil {
+			return "", err
+		}
+	}
+
+	for i := range testPaths {
+		if err := r.collect(r.rewriteFile(job, testPaths[i], testFiles[i])); err != nil {
+			return "", err
+		}
+	}
+	if len(testPaths) > 0 {
+		r.tested = append(r.tested, importPath)
+	}
+
 	return alias, nil
 }
 
-func (r *rewriter) rewriteFile(pkg *build.Package, src string) error {
-	code, err := ioutil.ReadFile(src)
-	if err != nil {
-		return err
+// renamePackage renames identifiers and encrypts strings in the job's
+// package. every platform is checked before any file is renamed, files
+// shared by several platforms are renamed once per platform to the same
+// aliases
+func (r *rewriter) renamePackage(job *packageJob) error {
+	pkg, tests := job.pkg, job.tests
+	r.current = job
+	defer func() { r.current = nil }()
+
+	var variants []*loadedPackage
+	for _, pl := range r.platforms {
+		p, err := pl.variant(pkg)
+		if err != nil {
+			return r.fail(CheckError, pkg.Dir, err)
+		}
+		if p == nil {
+			continue
+		}
+		if tests {
+			if err := pl.checkTests(p); err != nil {
+				return r.fail(CheckError, pkg.Dir, err)
+			}
+		}
+		variants = append(variants, p)
 	}
 
-	fset := token.NewFileSet()
-	file, err := parser.ParseFile(fset, src, code, parser.AllErrors)
-	if err != nil {
-		return err
+	// the test variant covers every file of the package plus its tests
+	var mains []*loadedPackage
+	for _, p := range variants {
+		if tests && p.test != nil {
+			mains = append(mains, p.test)
+		} else {
+			mains = append(mains, p)
+		}
 	}
 
-	dirAlias, err := r.namer.Alias(pkg.Dir)
-	if err != nil {
-		return err
+	if r.options.RenameIdentifiers {
+		for i, p := range variants {
+			if err := r.renameIdentifiers(mains[i]); err != nil {
+				return err
+			}
+			if tests && p.xtest != nil {
+				if err := r.renameIdentifiers(p.xtest); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	// encrypted literals may need to name renamed types so this runs last.
+	// the external test package can't reach the decoder so it's skipped
+	var files []*ast.File
+	for _, p := range mains {
+		files = append(files, p.files...)
+	}
+	if (r.options.EncryptStrings && r.selected(pkg)) || hasEncryptDirective(files) {
+		if err := r.encryptStrings(mains, job); err != nil {
+			return r.fail(WriteError, job.dir, err)
+		}
+	}
+	// flattening moves statements into cases and names the types of the
+	// variables it hoists, which are renamed by now
+	return r.flattenFunctions(mains)
+}
+
+// copyAlong records a path under the directory of a package that its
+// sources include, it's copied along with the package
+func (r *rewriter) copyAlong(dir, rel string) {
+	if rel == "." {
+		return
+	}
+	if r.copies[dir] == nil {
+		r.copies[dir] = make(map[string]struct{})
 	}
-	srcAlias, err := r.namer.Alias(src)
+	r.copies[dir][rel] = struct{}{}
+}
+
+// resolveImportPath recovers the import path of packages loaded by directory
+// in module mode from the module they belong to
+func (r *rewriter) resolveImportPath(pkg *build.Package) error {
+	if r.modules == nil || !build.IsLocalImport(pkg.ImportPath) {
+		return nil
+	}
+	importPath, err := r.modules.ImportPath(pkg.Dir)
 	if err != nil {
 		return err
 	}
-	srcAlias = fmt.Sprintf("%s.go", srcAlias)
+	pkg.ImportPath = importPath
+	return nil
+}
 
-	for _, imp := range file.Imports {
-		err := r.rewriteImport(filepath.Dir(src), imp)
+// isInternal reports whether pkg belongs to the project under RootPath
+func (r *rewriter) isInternal(pkg *build.Package) bool {
+	if pkg.Goroot || strings.Contains(pkg.Dir, vendorPath) {
+		return false
+	}
+	rel, err := filepath.Rel(r.options.RootPath, pkg.Dir)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// rewriteFile aliases the file and its imports, the job writes it
+func (r *rewriter) rewriteFile(job *packageJob, src string, file *ast.File) error {
+	pkg := job.pkg
+	srcAlias := filepath.Base(src)
+	if !r.keepFileName(pkg, srcAlias) {
+		// files are aliased by import path rather than location so seeded
+		// aliases don't depend on where the source is checked out
+		srcKey := path.Join(pkg.ImportPath, filepath.Base(src))
+		alias, err := r.namer.Alias(srcKey)
 		if err != nil {
 			return err
 		}
+		// platform suffixes constrain the file and go test finds tests by
+		// their suffix so both are kept
+		srcAlias = alias + platformSuffix(filepath.Base(src))
+		if strings.HasSuffix(src, "_test.go") {
+			srcAlias = fmt.Sprintf("%s_test.go", srcAlias)
+		} else {
+			srcAlias = fmt.Sprintf("%s.go", srcAlias)
+		}
+		r.mapping.Files[srcKey] = srcAlias
 	}
 
-	oldPath := path.Join(r.options.TargetPath, "src", dirAlias, path.Base(src))
-	err = os.Remove(oldPath)
+	r.tagReflectedFields(job, file)
+
+	// constraints are written ahead of the file so they're dropped from
+	// comments that are kept
+	if r.options.StripComments && r.selected(pkg) {
+		stripComments(file)
+	} else {
+		dropConstraints(file)
+	}
+	constraints, err := buildConstraints(src)
 	if err != nil {
-		return err
+		return r.fail(ParseError, src, err)
 	}
 
-	newPath := path.Join(r.options.TargetPath, "src", dirAlias, srcAlias)
-	fw, err := os.OpenFile(newPath, os.O_RDWR|os.O_TRUNC|os.O_CREATE, 0644)
-	if err != nil {
-		return err
+	planned := &PlannedFile{
+		Name:    filepath.Base(src),
+		Target:  srcAlias,
+		Imports: make(map[string]string),
 	}
-	if err := format.Node(fw, fset, file); err != nil {
-		return err
+	r.planned[pkg.Dir].Files = append(r.planned[pkg.Dir].Files, planned)
+
+	for _, imp := range file.Imports {
+		original := imp.Path.Value
+		err := r.rewriteImport(filepath.Dir(src), imp)
+		if err != nil {
+			return err
+		}
+		if imp.Path.Value != original {
+			planned.Imports[original[1:len(original)-1]] = imp.Path.Value[1 : len(imp.Path.Value)-1]
+		}
 	}
 
+	job.files = append(job.files, &outputFile{
+		src:         src,
+		alias:       srcAlias,
+		constraints: constraints,
+		file:        file,
+	})
 	return nil
 }
 
 func (r *rewriter) rewriteImport(srcDir string, imp *ast.ImportSpec) error {
 	importPath := imp.Path.Value[1 : len(imp.Path.Value)-1]
-	pkg, err := build.Default.Import(importPath, srcDir, 0)
+	// cgo's pseudo package
+	if importPath == "C" {
+		return nil
+	}
+	pkg, err := r.importPackage(importPath, srcDir)
 	if err != nil {
-		return err
+		// the import is left as it is and the file still written
+		return r.collect(r.fail(ResolveError, importPath, err))
+	}
+	if _, err := r.RewritePackage(pkg); err != nil {
+		if err := r.collect(err); err != nil {
+			return err
+		}
 	}
-	r.RewritePackage(pkg)
 
-	alias, err := r.namer.Alias(importPath)
+	alias, err := r.targetImportPath(pkg)
 	if err != nil {
 		return err
 	}
@@ -158,6 +663,119 @@ func (r *rewriter) rewriteImport(srcDir string, imp *ast.ImportSpec) error {
 	return nil
 }
 
+// targetImportPath is the path the aliased copy of pkg is imported by
+func (r *rewriter) targetImportPath(pkg *build.Package) (string, error) {
+	alias, err := r.namer.Alias(pkg.ImportPath)
+	if err != nil {
+		return "", err
+	}
+	if r.modules == nil || pkg.Goroot {
+		return alias, nil
+	}
+
+	m, err := r.modules.Lookup(pkg.Dir)
+	if err != nil {
+		return "", err
+	}
+	moduleAlias, err := r.moduleAlias(m)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(moduleAlias, alias), nil
+}
+
+// targetDir is the directory the aliased copy of pkg is written to. in GOPATH
+// mode packages live directly under src, in module mode the main module's
+// packages live in the target root and each dependency module gets its own
+// aliased module directory alongside them
+func (r *rewriter) targetDir(pkg *build.Package) (string, error) {
+	alias, err := r.namer.Alias(pkg.Dir)
+	if err != nil {
+		return "", err
+	}
+	if r.modules == nil {
+		return path.Join(r.options.TargetPath, "src", alias), nil
+	}
+
+	m, err := r.modules.Lookup(pkg.Dir)
+	if err != nil {
+		return "", err
+	}
+	if m.Main {
+		return path.Join(r.options.TargetPath, alias), nil
+	}
+	moduleAlias, err := r.moduleAlias(m)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(r.options.TargetPath, moduleAlias, alias), nil
+}
+
+// moduleAlias is the path the aliased copy of m is written under
+func (r *rewriter) moduleAlias(m *Module) (string, error) {
+	if r.keepModule(m) {
+		return m.Path, nil
+	}
+	return r.namer.Alias(moduleKey(m))
+}
+
+// keepPackagePath assigns pkg its original import path, or in module mode
+// its path within its module, instead of an alias
+func (r *rewriter) keepPackagePath(pkg *build.Package, names []string) (string, error) {
+	// vendored packages are imported by the path after vendor
+	alias := names[len(names)-1]
+	if r.modules != nil {
+		m, err := r.modules.Lookup(pkg.Dir)
+		if err != nil {
+			return "", err
+		}
+		alias = strings.TrimPrefix(strings.TrimPrefix(pkg.ImportPath, m.Path), "/")
+		if alias == "" {
+			alias = "."
+		}
+	}
+	r.namer.Assign(alias, names...)
+	return alias, nil
+}
+
+// writeModules synthesizes go.mod files for the target tree. the main module
+// requires every aliased dependency module and replaces it with its local copy
+func (r *rewriter) writeModules() error {
+	mainAlias, err := r.moduleAlias(r.modules.main)
+	if err != nil {
+		return err
+	}
+	if !r.keepModule(r.modules.main) {
+		r.mapping.Modules[moduleName(r.modules.main)] = mainAlias
+	}
+
+	var requires []string
+	replaces := make(map[string]string)
+	for _, m := range r.modules.Dependencies() {
+		alias, err := r.moduleAlias(m)
+		if err != nil {
+			return err
+		}
+		if !r.keepModule(m) {
+			r.mapping.Modules[moduleName(m)] = alias
+		}
+		requires = append(requires, alias)
+		replaces[alias] = "./" + alias
+		if r.options.DryRun {
+			continue
+		}
+		dir := path.Join(r.options.TargetPath, alias)
+		if err := writeGoMod(dir, alias, goVersion(m), nil, nil); err != nil {
+			return err
+		}
+	}
+
+	if r.options.DryRun {
+		return nil
+	}
+	return writeGoMod(r.options.TargetPath, mainAlias, goVersion(r.modules.main), requires, replaces)
+}
+
 func prefixDirectory(directory string, names []string) {
 	if directory != "." {
 		for i, name := range names {
@@ -0,0 +1,273 @@
+package obfuscator
+
+import (
+	"go/build"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTree writes files under dir by their slash separated paths
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// runTarget rewrites the project, builds the aliased main package in the
+// target and returns what it prints
+func runTarget(t *testing.T, options Options) string {
+	t.Helper()
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go tool not found")
+	}
+	alias, err := Rewrite(options)
+	if err != nil {
+		t.Fatalf("rewrite: %v", err)
+	}
+
+	bin := filepath.Join(t.TempDir(), "app")
+	build := exec.Command("go", "build", "-o", bin, alias)
+	if UsesModules(options) {
+		build.Dir = options.TargetPath
+		build.Env = append(os.Environ(), "GO111MODULE=on")
+	} else {
+		build.Env = append(os.Environ(), "GOPATH="+options.TargetPath, "GO111MODULE=off")
+	}
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("go build %s: %v\n%s", alias, err, out)
+	}
+	out, err := exec.Command(bin).CombinedOutput()
+	if err != nil {
+		t.Fatalf("run: %v\n%s", err, out)
+	}
+	return string(out)
+}
+
+// targetFiles lists the files of the target tree by slash separated paths
+func targetFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	var names []string
+	err := filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, name)
+		names = append(names, filepath.ToSlash(rel))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestRewriteModule(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"proj/go.mod": "module example.com/proj\n\ngo 1.16\n\nrequire example.com/dep v1.0.0\n\nreplace example.com/dep => ../dep\n",
+		"proj/cmd/app/main.go": `package main
+
+import (
+	"fmt"
+
+	"example.com/dep/greet"
+	"example.com/proj/internal/util"
+)
+
+func main() { fmt.Println(util.Join(greet.Hello(), "proj")) }
+`,
+		"proj/internal/util/util.go": `package util
+
+import "strings"
+
+// Join joins
+func Join(parts ...string) string { return strings.Join(parts, ",") }
+`,
+		"dep/go.mod": "module example.com/dep\n\ngo 1.16\n",
+		"dep/greet/greet.go": `package greet
+
+// Hello says hello
+func Hello() string { return "hello" }
+`,
+	})
+
+	target := t.TempDir()
+	options := Options{
+		SrcPath:    filepath.Join(root, "proj", "cmd", "app"),
+		RootPath:   filepath.Join(root, "proj"),
+		TargetPath: target,
+	}
+	if !UsesModules(options) {
+		t.Fatal("project not resolved as a module")
+	}
+	if out := runTarget(t, options); out != "hello,proj\n" {
+		t.Errorf("target printed %q", out)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(target, "go.mod"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "example.com") {
+		t.Errorf("target go.mod keeps original module paths:\n%s", data)
+	}
+	for _, name := range targetFiles(t, target) {
+		if strings.Contains(name, "example.com") || strings.Contains(name, "internal") {
+			t.Errorf("%s keeps an original path", name)
+		}
+	}
+}
+
+func TestRewriteModuleRootPackage(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"go.mod": "module example.com/proj\n\ngo 1.16\n",
+		"proj.go": `package proj
+
+import (
+	"embed"
+
+	"example.com/proj/internal/util"
+)
+
+//go:embed static
+var static embed.FS
+
+// Greeting greets
+func Greeting() string {
+	data, _ := static.ReadFile("static/hello.txt")
+	return util.Join(string(data), "proj")
+}
+`,
+		"NOTES.txt":        "notes\n",
+		"static/hello.txt": "hello",
+		"internal/util/util.go": `package util
+
+import "strings"
+
+// Join joins
+func Join(parts ...string) string { return strings.Join(parts, ",") }
+`,
+		"cmd/app/main.go": `package main
+
+import (
+	"fmt"
+
+	"example.com/proj"
+)
+
+func main() { fmt.Println(proj.Greeting()) }
+`,
+	})
+
+	target := t.TempDir()
+	out := runTarget(t, Options{
+		SrcPath:           filepath.Join(root, "cmd", "app"),
+		RootPath:          root,
+		TargetPath:        target,
+		RenameIdentifiers: true,
+	})
+	if out != "hello,proj\n" {
+		t.Errorf("target printed %q", out)
+	}
+
+	var goMods, notes, embedded int
+	for _, name := range targetFiles(t, target) {
+		switch {
+		case name == "go.mod":
+		case strings.HasSuffix(name, "/go.mod"):
+			goMods++
+		case strings.HasSuffix(name, "/NOTES.txt"):
+			notes++
+		case strings.HasSuffix(name, "/static/hello.txt"):
+			embedded++
+		case strings.Contains(name, "cmd/") || strings.Contains(name, "internal/") ||
+			strings.HasSuffix(name, "/main.go") || strings.HasSuffix(name, "/util.go"):
+			t.Errorf("original source copied to %s", name)
+		}
+	}
+	if goMods != 0 {
+		t.Errorf("module file copied into %d packages", goMods)
+	}
+	if notes != 1 || embedded != 1 {
+		t.Errorf("got %d copies of NOTES.txt and %d of static/hello.txt, want 1 each", notes, embedded)
+	}
+}
+
+func TestRewriteGOPATH(t *testing.T) {
+	gopath := t.TempDir()
+	root := filepath.Join(gopath, "src", "example.com", "gp")
+	writeTree(t, root, map[string]string{
+		"cmd/app/main.go": `package main
+
+import (
+	"fmt"
+
+	"example.com/dep"
+	"example.com/gp/lib"
+)
+
+func main() { fmt.Println(lib.Greeting(), dep.Name) }
+`,
+		"lib/lib.go": `package lib
+
+import "strings"
+
+// Greeting greets
+func Greeting() string { return strings.ToUpper("hello") }
+`,
+		"lib/data.txt": "data\n",
+		"vendor/example.com/dep/dep.go": `package dep
+
+// Name names
+const Name = "dep"
+`,
+	})
+
+	t.Setenv("GO111MODULE", "off")
+	context := build.Default
+	build.Default.GOPATH = gopath
+	t.Cleanup(func() { build.Default = context })
+
+	target := t.TempDir()
+	options := Options{
+		SrcPath:           filepath.Join(root, "cmd", "app"),
+		RootPath:          root,
+		TargetPath:        target,
+		RenameIdentifiers: true,
+		RenameExported:    true,
+	}
+	if UsesModules(options) {
+		t.Fatal("project resolved as a module")
+	}
+	if out := runTarget(t, options); out != "HELLO dep\n" {
+		t.Errorf("target printed %q", out)
+	}
+
+	var data int
+	for _, name := range targetFiles(t, target) {
+		if !strings.HasPrefix(name, "src/") {
+			t.Errorf("%s written outside of src", name)
+		}
+		if strings.Contains(name, "example.com") || strings.Contains(name, "vendor") {
+			t.Errorf("%s keeps an original path", name)
+		}
+		if strings.HasSuffix(name, "/data.txt") {
+			data++
+		}
+	}
+	if data != 1 {
+		t.Errorf("got %d copies of data.txt, want 1", data)
+	}
+}
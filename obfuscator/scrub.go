@@ -0,0 +1,371 @@
+package obfuscator
+
+import (
+	"bytes"
+	"compress/zlib"
+	"debug/elf"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// errors
+var (
+	ErrNotELF          = errors.New("scrub only supports ELF binaries")
+	ErrSectionTooLarge = errors.New("scrubbed section doesn't fit in place")
+)
+
+const (
+	scrubFiller = '_'
+
+	goBuildIDNote = ".note.go.buildid"
+)
+
+var (
+	// modinfo markers written around the embedded runtime/debug.BuildInfo
+	// by cmd/go
+	modInfoStart, _ = hex.DecodeString("3077af0c9274080241e1c107e6d618e6")
+	modInfoEnd, _   = hex.DecodeString("f932433186182072008242104116d8f2")
+)
+
+// ScrubOptions ...
+type ScrubOptions struct {
+	// Strings are overwritten wherever they appear in the binary as a path
+	// or a name of their own, including compressed DWARF sections. Typically
+	// GOROOT, GOPATH and the original module and import paths
+	Strings []string
+
+	// OutputPath is where the scrubbed binary is written, the input is
+	// scrubbed in place if it's empty
+	OutputPath string
+}
+
+// ScrubReport counts the replacements made by Scrub
+type ScrubReport struct {
+	Strings       map[string]int
+	BuildID       bool
+	BuildInfo     bool
+	DWARFSections []string
+}
+
+// Scrub removes identifying strings and build metadata that survive source
+// level rewriting from an ELF binary. Every replacement has the same length
+// as what it replaces so nothing loaded at run time moves, compressed DWARF
+// sections are recompressed in place or appended to the file
+func Scrub(filename string, options ScrubOptions) (*ScrubReport, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrNotELF
+	}
+	defer f.Close()
+
+	s := &scrubber{
+		data:    data,
+		file:    f,
+		strings: dedupeStrings(options.Strings),
+		report:  &ScrubReport{Strings: make(map[string]int)},
+	}
+
+	if err := s.scrubBuildID(); err != nil {
+		return nil, err
+	}
+	s.scrubBuildInfo()
+	if err := s.scrubCompressedSections(); err != nil {
+		return nil, err
+	}
+	s.scrubStrings(s.data)
+
+	output := options.OutputPath
+	if output == "" {
+		output = filename
+	}
+	if err := ioutil.WriteFile(output, s.data, 0755); err != nil {
+		return nil, err
+	}
+	return s.report, nil
+}
+
+// DefaultScrubStrings returns the GOROOT, GOPATH and module cache of the
+// installed go command
+func DefaultScrubStrings() ([]string, error) {
+	out, err := exec.Command("go", "env", "GOROOT", "GOPATH", "GOMODCACHE").Output()
+	if err != nil {
+		return nil, err
+	}
+	var values []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			values = append(values, line)
+		}
+	}
+	return values, nil
+}
+
+type scrubber struct {
+	data    []byte
+	file    *elf.File
+	strings []string
+	report  *ScrubReport
+}
+
+// dedupeStrings drops empty and duplicate strings and sorts the rest longest
+// first so a string is replaced before any string it contains
+func dedupeStrings(values []string) []string {
+	seen := make(map[string]struct{})
+	var out []string
+	for _, value := range values {
+		if _, ok := seen[value]; ok || value == "" {
+			continue
+		}
+		seen[value] = struct{}{}
+		out = append(out, value)
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return len(out[i]) > len(out[j])
+	})
+	return out
+}
+
+// scrubStrings overwrites every scrubbed string in data and returns how
+// many were found
+func (s *scrubber) scrubStrings(data []byte) int {
+	total := 0
+	for _, value := range s.strings {
+		n := fillPaths(data, []byte(value))
+		if n > 0 {
+			s.report.Strings[value] += n
+			total += n
+		}
+	}
+	return total
+}
+
+// scrubBuildID overwrites the go build ID, it's both in its note and at the
+// start of the text segment
+func (s *scrubber) scrubBuildID() error {
+	section := s.file.Section(goBuildIDNote)
+	if section == nil {
+		return nil
+	}
+	note, err := section.Data()
+	if err != nil {
+		return err
+	}
+	// namesz, descsz and type followed by the padded name and the id
+	if len(note) < 16 {
+		return nil
+	}
+	order := s.file.ByteOrder
+	nameSize := int(order.Uint32(note[0:]))
+	descSize := int(order.Uint32(note[4:]))
+	start := 12 + (nameSize+3)&^3
+	if start+descSize > len(note) {
+		return nil
+	}
+	id := note[start : start+descSize]
+
+	if fill(s.data, append([]byte{}, id...)) > 0 {
+		s.report.BuildID = true
+	}
+	return nil
+}
+
+// scrubBuildInfo blanks the build settings recorded in the embedded module
+// info, which include linker flags, cgo flags and version control state.
+// Lines of spaces are skipped by runtime/debug so the module paths and
+// versions can still be read
+func (s *scrubber) scrubBuildInfo() {
+	data := s.data
+	for {
+		start := bytes.Index(data, modInfoStart)
+		if start == -1 {
+			return
+		}
+		data = data[start+len(modInfoStart):]
+		end := bytes.Index(data, modInfoEnd)
+		if end == -1 {
+			return
+		}
+
+		info := data[:end]
+		for len(info) > 0 {
+			line := info
+			if i := bytes.IndexByte(info, '\n'); i != -1 {
+				line = info[:i]
+				info = info[i+1:]
+			} else {
+				info = nil
+			}
+			if bytes.HasPrefix(line, []byte("build\t")) {
+				for i := range line {
+					line[i] = ' '
+				}
+				s.report.BuildInfo = true
+			}
+		}
+		data = data[end+len(modInfoEnd):]
+	}
+}
+
+// scrubCompressedSections scrubs the strings in compressed debug sections.
+// Same length replacements don't change the uncompressed size, the
+// recompressed data is written over the original or, if it grew, moved to
+// the end of the file
+func (s *scrubber) scrubCompressedSections() error {
+	shoff, shentsize := s.sectionHeaders()
+	for i, section := range s.file.Sections {
+		compressed := section.Flags&elf.SHF_COMPRESSED != 0
+		legacy := strings.HasPrefix(section.Name, ".zdebug_")
+		if (!compressed && !legacy) || section.Type == elf.SHT_NOBITS {
+			continue
+		}
+
+		raw := s.data[section.Offset : section.Offset+section.FileSize]
+		// Chdr for SHF_COMPRESSED, "ZLIB" and the uncompressed size for
+		// .zdebug sections
+		headerSize := 12
+		if compressed && s.file.Class == elf.ELFCLASS64 {
+			headerSize = 24
+		}
+		if len(raw) < headerSize {
+			continue
+		}
+
+		r, err := zlib.NewReader(bytes.NewReader(raw[headerSize:]))
+		if err != nil {
+			return err
+		}
+		plain, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		if s.scrubStrings(plain) == 0 {
+			continue
+		}
+
+		var buf bytes.Buffer
+		w, err := zlib.NewWriterLevel(&buf, zlib.BestCompression)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(plain); err != nil {
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+		header := append([]byte{}, raw[:headerSize]...)
+		for j := range raw {
+			raw[j] = 0
+		}
+
+		offset := section.Offset
+		if headerSize+buf.Len() > len(raw) {
+			// sections outside of any segment can live anywhere in the file,
+			// a larger one is moved to the end
+			if section.Flags&elf.SHF_ALLOC != 0 {
+				return ErrSectionTooLarge
+			}
+			offset = uint64(len(s.data))
+			if align := section.Addralign; align > 1 && offset%align != 0 {
+				s.data = append(s.data, make([]byte, align-offset%align)...)
+				offset = uint64(len(s.data))
+			}
+			s.data = append(s.data, make([]byte, headerSize+buf.Len())...)
+		}
+		copy(s.data[offset:], header)
+		copy(s.data[offset+uint64(headerSize):], buf.Bytes())
+		s.setSection(shoff+int64(i)*int64(shentsize), offset, uint64(headerSize+buf.Len()))
+		s.report.DWARFSections = append(s.report.DWARFSections, section.Name)
+	}
+	return nil
+}
+
+// sectionHeaders reads the offset and entry size of the section header
+// table, debug/elf doesn't expose them
+func (s *scrubber) sectionHeaders() (int64, int) {
+	order := s.file.ByteOrder
+	if s.file.Class == elf.ELFCLASS64 {
+		return int64(order.Uint64(s.data[0x28:])), int(order.Uint16(s.data[0x3a:]))
+	}
+	return int64(order.Uint32(s.data[0x20:])), int(order.Uint16(s.data[0x2e:]))
+}
+
+// setSection updates sh_offset and sh_size of the section header at header
+func (s *scrubber) setSection(header int64, offset, size uint64) {
+	order := s.file.ByteOrder
+	if s.file.Class == elf.ELFCLASS64 {
+		order.PutUint64(s.data[header+0x18:], offset)
+		order.PutUint64(s.data[header+0x20:], size)
+		return
+	}
+	order.PutUint32(s.data[header+0x10:], uint32(offset))
+	order.PutUint32(s.data[header+0x14:], uint32(size))
+}
+
+// fill overwrites every occurrence of value in data with the filler and
+// returns how many there were
+func fill(data, value []byte) int {
+	n := 0
+	for {
+		i := bytes.Index(data, value)
+		if i == -1 {
+			return n
+		}
+		for j := range value {
+			data[i+j] = scrubFiller
+		}
+		data = data[i+len(value):]
+		n++
+	}
+}
+
+// fillPaths overwrites the occurrences of value in data that stand on their
+// own, see pathBounded, and returns how many there were. A short path like
+// app is left alone inside append or application/json
+func fillPaths(data, value []byte) int {
+	n := 0
+	for start := 0; ; {
+		i := bytes.Index(data[start:], value)
+		if i == -1 {
+			return n
+		}
+		i += start
+		if !pathBounded(data, i, len(value)) {
+			start = i + 1
+			continue
+		}
+		for j := range value {
+			data[i+j] = scrubFiller
+		}
+		start = i + len(value)
+		n++
+	}
+}
+
+// pathBounded reports whether the n bytes at i in data are a whole path or
+// name rather than part of a longer one. Neither byte around them can be part
+// of a path element, like the slashes, NULs and quotes paths are stored
+// between, but a . may follow a package path in symbol names
+func pathBounded(data []byte, i, n int) bool {
+	if i > 0 && isPathByte(data[i-1]) {
+		return false
+	}
+	end := i + n
+	return end == len(data) || !isPathByte(data[end]) || data[end] == '.'
+}
+
+// isPathByte reports whether b can be part of an element of an import path or
+// a file name
+func isPathByte(b byte) bool {
+	return 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || '0' <= b && b <= '9' ||
+		b == '-' || b == '_' || b == '.' || b == '~' || b == '+'
+}
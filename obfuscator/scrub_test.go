@@ -0,0 +1,45 @@
+package obfuscator
+
+import "testing"
+
+func TestFillPaths(t *testing.T) {
+	tests := []struct {
+		data  string
+		value string
+		want  string
+		n     int
+	}{
+		{"app", "app", "___", 1},
+		{"append", "app", "append", 0},
+		{"application/json", "app", "application/json", 0},
+		{"rapid", "api", "rapid", 0},
+		{"example.com/app", "app", "example.com/___", 1},
+		{"example.com/app/users", "example.com/app", "_______________/users", 1},
+		{"example.com/app.Handler", "example.com/app", "_______________.Handler", 1},
+		{"example.com/apps/x", "example.com/app", "example.com/apps/x", 0},
+		{"\x00/root/src/app\x00", "/root/src/app", "\x00_____________\x00", 1},
+		{`"app" "app"`, "app", `"___" "___"`, 2},
+		{"*example.com/app.T", "example.com/app", "*_______________.T", 1},
+		{"appapp app", "app", "appapp ___", 1},
+	}
+	for _, test := range tests {
+		data := []byte(test.data)
+		n := fillPaths(data, []byte(test.value))
+		if string(data) != test.want || n != test.n {
+			t.Errorf("fillPaths(%q, %q) = %q, %d, want %q, %d", test.data, test.value, data, n, test.want, test.n)
+		}
+	}
+}
+
+func TestDedupeStrings(t *testing.T) {
+	got := dedupeStrings([]string{"a", "", "abc", "ab", "a", "abc"})
+	want := []string{"abc", "ab", "a"}
+	if len(got) != len(want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+}
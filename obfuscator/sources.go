@@ -0,0 +1,242 @@
+package obfuscator
+
+import (
+	"bytes"
+	"errors"
+	"go/ast"
+	"go/build"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// errors
+var (
+	ErrIncludePath = errors.New("include path is outside of every package")
+)
+
+var (
+	includeDirective = regexp.MustCompile(`(#\s*include\s+")([^"]+)(")`)
+	srcdirPath       = regexp.MustCompile(`(\$\{SRCDIR\}/)([^\s"']+)`)
+
+	// assembly names symbols as pkg·name with ∕ standing in for / in the
+	// package path, local symbols leave the path out
+	asmSymbol = regexp.MustCompile(`([\p{L}\p{N}_.%∕]*)·([\p{L}_][\p{L}\p{N}_]*)`)
+)
+
+// rewriteSources rewrites the non-Go sources of pkg in dir along with the cgo
+// preambles of its Go files. Relative includes that leave the package follow
+// the directories they point into and assembly symbols follow their package
+// and identifier aliases. The files keep their names, headers are included by
+// name and file name suffixes carry build constraints
+func (r *rewriter) rewriteSources(job *packageJob, cgoFiles []*ast.File) error {
+	pkg := job.pkg
+	for _, file := range cgoFiles {
+		for _, group := range file.Comments {
+			for _, c := range group.List {
+				text, err := r.rewriteIncludes(pkg, c.Text)
+				if err != nil {
+					return err
+				}
+				c.Text = text
+			}
+		}
+	}
+
+	var names []string
+	names = append(names, pkg.CFiles...)
+	names = append(names, pkg.CXXFiles...)
+	names = append(names, pkg.MFiles...)
+	names = append(names, pkg.HFiles...)
+	names = append(names, pkg.SFiles...)
+	for _, name := range names {
+		data, err := ioutil.ReadFile(filepath.Join(pkg.Dir, name))
+		if err != nil {
+			return err
+		}
+
+		text, err := r.rewriteIncludes(pkg, string(data))
+		if err != nil {
+			return err
+		}
+		if strings.HasSuffix(name, ".s") {
+			text, err = r.rewriteAsmSymbols(pkg, text)
+			if err != nil {
+				return err
+			}
+		}
+
+		if text != string(data) {
+			job.generated = append(job.generated, &generatedFile{name: name, data: []byte(text)})
+		}
+	}
+
+	return nil
+}
+
+// rewriteIncludes rewrites quoted #include paths and ${SRCDIR} paths in cgo
+// flags relative to pkg
+func (r *rewriter) rewriteIncludes(pkg *build.Package, text string) (string, error) {
+	var err error
+	rewrite := func(re *regexp.Regexp, text string) string {
+		return re.ReplaceAllStringFunc(text, func(match string) string {
+			m := re.FindStringSubmatch(match)
+			rel, rerr := r.includePath(pkg, m[2])
+			if rerr != nil {
+				err = rerr
+				return match
+			}
+			return m[1] + rel + strings.Join(m[3:], "")
+		})
+	}
+
+	text = rewrite(includeDirective, text)
+	text = rewrite(srcdirPath, text)
+	return text, err
+}
+
+// includePath maps a path relative to the source directory of pkg to the
+// same file in the target tree. Paths inside the package directory are
+// copied along with it and stay as they are
+func (r *rewriter) includePath(pkg *build.Package, rel string) (string, error) {
+	clean := filepath.Clean(rel)
+	if filepath.IsAbs(clean) {
+		return rel, nil
+	}
+	if clean != ".." && !strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		r.copyAlong(pkg.Dir, clean)
+		return rel, nil
+	}
+
+	target, err := r.sourceTarget(filepath.Join(pkg.Dir, clean))
+	if err != nil {
+		return "", err
+	}
+	pkgDir, err := r.targetDir(pkg)
+	if err != nil {
+		return "", err
+	}
+	target, err = filepath.Rel(pkgDir, target)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(target), nil
+}
+
+// sourceTarget finds where the source file or directory src is copied to in
+// the target tree. It's copied along with the nearest package above it,
+// which is rewritten if it hasn't been yet
+func (r *rewriter) sourceTarget(src string) (string, error) {
+	for ancestor := filepath.Dir(src); ; {
+		pkg, err := r.importDir(ancestor)
+		if err == nil && !pkg.Goroot {
+			if _, err := r.RewritePackage(pkg); err != nil {
+				return "", err
+			}
+			target, err := r.targetDir(pkg)
+			if err != nil {
+				return "", err
+			}
+			rel, err := filepath.Rel(ancestor, src)
+			if err != nil {
+				return "", err
+			}
+			r.copyAlong(ancestor, rel)
+			return filepath.Join(target, rel), nil
+		}
+
+		parent := filepath.Dir(ancestor)
+		if parent == ancestor {
+			return "", ErrIncludePath
+		}
+		ancestor = parent
+	}
+}
+
+// rewriteAsmSymbols rewrites the package paths and names of symbols
+// referenced by the assembly in text
+func (r *rewriter) rewriteAsmSymbols(pkg *build.Package, text string) (string, error) {
+	var err error
+	text = asmSymbol.ReplaceAllStringFunc(text, func(match string) string {
+		m := asmSymbol.FindStringSubmatch(match)
+		prefix, name := m[1], m[2]
+
+		ref := pkg.ImportPath
+		if prefix != "" {
+			importPath := strings.Replace(prefix, "∕", "/", -1)
+			importPath = strings.Replace(importPath, "%2e", ".", -1)
+			refPkg, ierr := r.importPackage(importPath, pkg.Dir)
+			if ierr != nil || refPkg.Goroot {
+				// symbols the linker provides don't belong to an importable
+				// package
+				return match
+			}
+			if _, ierr := r.RewritePackage(refPkg); ierr != nil {
+				err = ierr
+				return match
+			}
+			alias, ierr := r.targetImportPath(refPkg)
+			if ierr != nil {
+				err = ierr
+				return match
+			}
+			prefix = strings.Replace(alias, "/", "∕", -1)
+			ref = refPkg.ImportPath
+		}
+
+		if r.options.RenameIdentifiers {
+			if obj := r.lookupObject(ref, name); obj != nil {
+				if key, ok := r.identifierKey(obj); ok {
+					alias, aerr := r.aliasIdent(key, obj.Exported())
+					if aerr != nil {
+						err = aerr
+						return match
+					}
+					name = alias
+				}
+			}
+		}
+
+		return prefix + "·" + name
+	})
+	return text, err
+}
+
+// isOpaque reports whether sources in pkg that can't be rewritten refer to
+// its Go declarations by name. go_asm.h exposes constants, types and field
+// offsets to assembly and SWIG generates Go code of its own
+func isOpaque(pkg *build.Package) (bool, error) {
+	if len(pkg.SwigFiles) > 0 || len(pkg.SwigCXXFiles) > 0 {
+		return true, nil
+	}
+	for _, name := range pkg.SFiles {
+		data, err := ioutil.ReadFile(filepath.Join(pkg.Dir, name))
+		if err != nil {
+			return false, err
+		}
+		if bytes.Contains(data, []byte(`"go_asm.h"`)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// cgoExportNames collects the names of functions exported to C
+func cgoExportNames(p *loadedPackage) map[string]struct{} {
+	names := make(map[string]struct{})
+	for _, file := range p.files {
+		for _, decl := range file.Decls {
+			fd, ok := decl.(*ast.FuncDecl)
+			if !ok || fd.Doc == nil {
+				continue
+			}
+			for _, c := range fd.Doc.List {
+				if strings.HasPrefix(c.Text, "//export ") {
+					names[strings.TrimSpace(strings.TrimPrefix(c.Text, "//export "))] = struct{}{}
+				}
+			}
+		}
+	}
+	return names
+}
@@ -0,0 +1,208 @@
+package obfuscator
+
+import (
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestRewriteAssembly(t *testing.T) {
+	if runtime.GOARCH != "amd64" {
+		t.Skip("assembly is written for amd64")
+	}
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"go.mod": "module example.com/asm\n\ngo 1.16\n",
+		"cmd/app/main.go": `package main
+
+import (
+	"fmt"
+
+	"example.com/asm/ops"
+)
+
+func main() { fmt.Println(ops.Add(1, 2)) }
+`,
+		"ops/ops.go": `package ops
+
+var offset = 100
+
+func add(a, b int) int
+
+// Add sums a, b and the offset in assembly
+func Add(a, b int) int { return add(a, b) }
+`,
+		"ops/ops_amd64.s": `#include "textflag.h"
+
+// func add(a, b int) int
+TEXT ·add(SB),NOSPLIT,$0-24
+	MOVQ a+0(FP), AX
+	ADDQ b+8(FP), AX
+	ADDQ ·offset(SB), AX
+	MOVQ AX, ret+16(FP)
+	RET
+`,
+	})
+
+	target := t.TempDir()
+	out := runTarget(t, Options{
+		SrcPath:           filepath.Join(root, "cmd", "app"),
+		RootPath:          root,
+		TargetPath:        target,
+		RenameIdentifiers: true,
+	})
+	if out != "103\n" {
+		t.Errorf("target printed %q, want %q", out, "103\n")
+	}
+
+	for _, name := range targetFiles(t, target) {
+		if !strings.HasSuffix(name, ".s") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(target, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), "·offset") {
+			t.Errorf("%s still references ·offset", name)
+		}
+		if !strings.Contains(string(data), "·add") {
+			t.Errorf("%s renamed the bodyless ·add", name)
+		}
+	}
+}
+
+func TestRewriteCgo(t *testing.T) {
+	if _, err := exec.LookPath("gcc"); err != nil || !build.Default.CgoEnabled {
+		t.Skip("cgo isn't available")
+	}
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"go.mod": "module example.com/cgo\n\ngo 1.16\n",
+		"cmd/app/main.go": `package main
+
+import (
+	"fmt"
+
+	"example.com/cgo/native"
+)
+
+func main() { fmt.Println(native.Triple(7)) }
+`,
+		"shared/shared.go": "package shared\n",
+		"shared/helper.h":  "static int triple(int x) { return 3 * x; }\n",
+		"native/native.go": `package native
+
+/*
+#include "../shared/helper.h"
+*/
+import "C"
+
+// Triple multiplies n by three in C
+func Triple(n int) int { return int(C.triple(C.int(n))) }
+`,
+		"native/export.go": `package native
+
+import "C"
+
+// callback is called from C
+//export callback
+func callback() C.int { return 1 }
+`,
+	})
+
+	target := t.TempDir()
+	out := runTarget(t, Options{
+		SrcPath:           filepath.Join(root, "cmd", "app"),
+		RootPath:          root,
+		TargetPath:        target,
+		RenameIdentifiers: true,
+		StripComments:     true,
+	})
+	if out != "21\n" {
+		t.Errorf("target printed %q, want %q", out, "21\n")
+	}
+
+	var sources strings.Builder
+	for _, name := range targetFiles(t, target) {
+		if strings.HasSuffix(name, ".go") {
+			data, err := ioutil.ReadFile(filepath.Join(target, filepath.FromSlash(name)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			sources.Write(data)
+		}
+	}
+	for _, text := range []string{`#include "../shared/helper.h"`, "called from C", "multiplies"} {
+		if strings.Contains(sources.String(), text) {
+			t.Errorf("target sources still contain %q", text)
+		}
+	}
+	if !strings.Contains(sources.String(), "//export callback") {
+		t.Error("//export directive was dropped")
+	}
+}
+
+func TestIsOpaque(t *testing.T) {
+	tests := []struct {
+		files map[string]string
+		want  bool
+	}{
+		{map[string]string{"a.go": "package a\n"}, false},
+		{map[string]string{"a.go": "package a\n", "a_amd64.s": "TEXT ·f(SB),0,$0\n\tRET\n"}, false},
+		{map[string]string{"a.go": "package a\n", "a_amd64.s": "#include \"go_asm.h\"\n"}, true},
+		{map[string]string{"a.go": "package a\n", "a.swig": ""}, true},
+	}
+	for i, test := range tests {
+		dir := t.TempDir()
+		writeTree(t, dir, test.files)
+		pkg, err := build.ImportDir(dir, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := isOpaque(pkg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("%d: isOpaque = %v, want %v", i, got, test.want)
+		}
+	}
+}
+
+func TestCgoExportNames(t *testing.T) {
+	const src = `package p
+
+import "C"
+
+// f is exported
+//export f
+func f() {}
+
+//export renamedInC
+func g() {}
+
+// h isn't
+func h() {}
+`
+	file, err := parser.ParseFile(token.NewFileSet(), "p.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := cgoExportNames(&loadedPackage{files: []*ast.File{file}})
+	if len(names) != 2 {
+		t.Errorf("got %v, want f and renamedInC", names)
+	}
+	for _, name := range []string{"f", "renamedInC"} {
+		if _, ok := names[name]; !ok {
+			t.Errorf("%s is missing from %v", name, names)
+		}
+	}
+}
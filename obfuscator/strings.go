@@ -0,0 +1,517 @@
+package obfuscator
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/format"
+	"go/token"
+	"go/types"
+	"path"
+	"strconv"
+)
+
+const (
+	stringKeySize = 32
+)
+
+// stringEncrypter replaces the string literals of one package with calls to
+// a decoder generated into the package. Literals are xored with a key derived
+// from the build key and the package path
+type stringEncrypter struct {
+	r        *rewriter
+	p        *loadedPackage
+	variants []*loadedPackage
+	key      []byte
+	decoder  string
+
+	// positions where the language requires a constant
+	constant []ast.Node
+	literals int
+	used     bool
+}
+
+// newStringKey generates the key strings are encrypted with for a build
+func newStringKey(namer *Namer) ([]byte, error) {
+	return namer.Bytes("strings:key", stringKeySize)
+}
+
+// encryptStrings rewrites the string literals of a package as checked for
+// every platform and adds the decoder they call to the job
+func (r *rewriter) encryptStrings(variants []*loadedPackage, job *packageJob) error {
+	if len(variants) == 0 {
+		return nil
+	}
+	p := variants[0]
+	sum := sha256.Sum256(append(append([]byte{}, r.stringKey...), p.types.Path()...))
+
+	// files shared by platforms are encrypted once with the type information
+	// of the first platform that builds them
+	var files []*ast.File
+	owners := make(map[*ast.File]*loadedPackage)
+	for _, variant := range variants {
+		for _, file := range variant.files {
+			if _, ok := owners[file]; !ok {
+				owners[file] = variant
+				files = append(files, file)
+			}
+		}
+	}
+
+	for _, file := range files {
+		ast.Inspect(file, func(n ast.Node) bool {
+			if ident, ok := n.(*ast.Ident); ok {
+				r.namer.Reserve(ident.Name)
+			}
+			return true
+		})
+	}
+
+	decoder, err := r.aliasIdent(p.types.Path()+".decoder:string", false)
+	if err != nil {
+		return err
+	}
+
+	e := &stringEncrypter{
+		r:        r,
+		p:        p,
+		variants: variants,
+		key:      sum[:],
+		decoder:  decoder,
+	}
+	for _, file := range files {
+		e.findConstantContexts(file)
+	}
+	for _, file := range files {
+		e.p = owners[file]
+		e.convertConstants(file)
+		e.encryptLiterals(file)
+	}
+
+	if !e.used {
+		return nil
+	}
+	return e.writeDecoder(job)
+}
+
+// findConstantContexts records the nodes whose literals must stay constant
+func (e *stringEncrypter) findConstantContexts(file *ast.File) {
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.ImportSpec:
+			e.constant = append(e.constant, n)
+		case *ast.Field:
+			if n.Tag != nil {
+				e.constant = append(e.constant, n.Tag)
+			}
+		case *ast.ArrayType:
+			if n.Len != nil {
+				e.constant = append(e.constant, n.Len)
+			}
+		case *ast.GenDecl:
+			if n.Tok == token.CONST {
+				e.constant = append(e.constant, n)
+			}
+		}
+		return true
+	})
+}
+
+func (e *stringEncrypter) isConstantContext(pos token.Pos) bool {
+	for _, n := range e.constant {
+		if n.Pos() <= pos && pos < n.End() {
+			return true
+		}
+	}
+	return false
+}
+
+// encryptLiterals replaces every string literal outside of constant contexts
+func (e *stringEncrypter) encryptLiterals(file *ast.File) {
+	replace := func(expr ast.Expr) ast.Expr {
+		lit, ok := expr.(*ast.BasicLit)
+		if !ok || lit.Kind != token.STRING || e.isConstantContext(lit.Pos()) {
+			return expr
+		}
+		if !e.r.encryptsLiteral(e.p.build, lit.Pos()) {
+			return expr
+		}
+		value, err := strconv.Unquote(lit.Value)
+		if err != nil || value == "" {
+			return expr
+		}
+		call, ok := e.decodeExpr(value, e.p.info.Types[lit].Type, lit.Pos())
+		if !ok {
+			return expr
+		}
+		return call
+	}
+
+	ast.Inspect(file, func(n ast.Node) bool {
+		walkExprs(n, replace)
+		return true
+	})
+}
+
+// convertConstants turns string constants that are only used where a
+// variable would do into variables so their values can be encrypted
+func (e *stringEncrypter) convertConstants(file *ast.File) {
+	var decls []ast.Decl
+	for _, decl := range file.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.CONST {
+			decls = append(decls, decl)
+			continue
+		}
+		split := e.splitConstDecl(gd)
+		if split == nil {
+			decls = append(decls, decl)
+			continue
+		}
+		for _, d := range split {
+			decls = append(decls, d)
+		}
+	}
+	file.Decls = decls
+
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.BlockStmt:
+			n.List = e.convertConstantStmts(n.List)
+		case *ast.CaseClause:
+			n.Body = e.convertConstantStmts(n.Body)
+		case *ast.CommClause:
+			n.Body = e.convertConstantStmts(n.Body)
+		}
+		return true
+	})
+}
+
+func (e *stringEncrypter) convertConstantStmts(list []ast.Stmt) []ast.Stmt {
+	var stmts []ast.Stmt
+	for _, stmt := range list {
+		ds, ok := stmt.(*ast.DeclStmt)
+		if !ok {
+			stmts = append(stmts, stmt)
+			continue
+		}
+		gd, ok := ds.Decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.CONST {
+			stmts = append(stmts, stmt)
+			continue
+		}
+		split := e.splitConstDecl(gd)
+		if split == nil {
+			stmts = append(stmts, stmt)
+			continue
+		}
+		for _, d := range split {
+			stmts = append(stmts, &ast.DeclStmt{Decl: d})
+		}
+	}
+	return stmts
+}
+
+// splitConstDecl splits a const declaration into runs of specs that stay
+// constants and runs that become variables, in their order and at their
+// positions so comments stay in place. It returns nil if no spec is converted
+func (e *stringEncrypter) splitConstDecl(gd *ast.GenDecl) []*ast.GenDecl {
+	// taking a spec out of its group would shift iota in the specs after it
+	if len(gd.Specs) > 1 && e.usesIota(gd) {
+		return nil
+	}
+
+	var decls []*ast.GenDecl
+	// ends holds where the source of each run ends, converted specs don't
+	// span the literals and comments they replace
+	var ends []token.Pos
+	converted := false
+	for i, spec := range gd.Specs {
+		vs := spec.(*ast.ValueSpec)
+		tok := token.CONST
+		// a following spec without values repeats this one's expressions
+		repeated := i+1 < len(gd.Specs) && len(gd.Specs[i+1].(*ast.ValueSpec).Values) == 0
+		if !repeated {
+			if v, ok := e.convertConstSpec(vs); ok {
+				spec, tok, converted = v, token.VAR, true
+			}
+		}
+		if n := len(decls); n > 0 && decls[n-1].Tok == tok {
+			decls[n-1].Specs = append(decls[n-1].Specs, spec)
+			ends[n-1] = specEnd(vs)
+			continue
+		}
+		decls = append(decls, &ast.GenDecl{Tok: tok, Specs: []ast.Spec{spec}})
+		ends = append(ends, specEnd(vs))
+	}
+	if !converted {
+		return nil
+	}
+
+	decls[0].Doc = gd.Doc
+	for i, d := range decls {
+		if len(d.Specs) == 1 {
+			d.TokPos = d.Specs[0].Pos()
+			continue
+		}
+		// a run opens where the one before it ends for the comments of its
+		// first spec to follow the parenthesis
+		if i == 0 {
+			d.TokPos, d.Lparen = gd.TokPos, gd.Lparen
+		} else {
+			d.TokPos, d.Lparen = ends[i-1], ends[i-1]
+		}
+		if i == len(decls)-1 {
+			d.Rparen = gd.Rparen
+		} else {
+			d.Rparen = ends[i]
+		}
+	}
+	return decls
+}
+
+func (e *stringEncrypter) usesIota(gd *ast.GenDecl) bool {
+	iota := types.Universe.Lookup("iota")
+	found := false
+	ast.Inspect(gd, func(n ast.Node) bool {
+		if ident, ok := n.(*ast.Ident); ok && e.p.info.Uses[ident] == iota {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// specEnd is where a spec ends in the source, past its line comment
+func specEnd(vs *ast.ValueSpec) token.Pos {
+	if vs.Comment != nil {
+		return vs.Comment.End()
+	}
+	return vs.End()
+}
+
+// convertConstSpec builds a var spec initialized with encrypted values for a
+// spec declaring only unexported string constants
+func (e *stringEncrypter) convertConstSpec(vs *ast.ValueSpec) (*ast.ValueSpec, bool) {
+	if len(vs.Values) != len(vs.Names) {
+		return nil, false
+	}
+
+	spec := &ast.ValueSpec{
+		Doc:     vs.Doc,
+		Names:   vs.Names,
+		Comment: vs.Comment,
+	}
+	for i, name := range vs.Names {
+		obj, ok := e.p.info.Defs[name].(*types.Const)
+		if !ok || obj.Exported() || obj.Val().Kind() != constant.String || !e.isVariable(obj) {
+			return nil, false
+		}
+		value := constant.StringVal(obj.Val())
+		if value == "" || !e.r.encryptsLiteral(e.p.build, vs.Values[i].Pos()) {
+			return nil, false
+		}
+
+		t := obj.Type()
+		if basic, ok := t.(*types.Basic); ok && basic.Info()&types.IsUntyped != 0 {
+			t = types.Typ[types.String]
+		}
+		call, ok := e.decodeExpr(value, t, vs.Values[i].Pos())
+		if !ok {
+			return nil, false
+		}
+		spec.Values = append(spec.Values, call)
+	}
+
+	return spec, true
+}
+
+// isVariable reports whether every use of obj on every platform would
+// accept a variable of the constant's type in its place. each platform has
+// its own object for the constant so they're matched by declaration
+func (e *stringEncrypter) isVariable(obj *types.Const) bool {
+	for _, variant := range e.variants {
+		for ident, use := range variant.info.Uses {
+			c, ok := use.(*types.Const)
+			if !ok || c.Pos() != obj.Pos() {
+				continue
+			}
+			if e.isConstantContext(ident.Pos()) {
+				return false
+			}
+
+			want := types.Unalias(c.Type())
+			if basic, ok := want.(*types.Basic); ok && basic.Info()&types.IsUntyped != 0 {
+				want = types.Typ[types.String]
+			}
+			if t := variant.info.Types[ident].Type; t != nil && !types.Identical(t, want) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// decodeExpr builds a call to the decoder returning value as type t, or
+// false if there's no way to name t here. The call is positioned at pos, the
+// expression it replaces, so the printer keeps comments around it in place
+func (e *stringEncrypter) decodeExpr(value string, t types.Type, pos token.Pos) (ast.Expr, bool) {
+	var conversion ast.Expr
+	switch t := types.Unalias(t).(type) {
+	case *types.Basic:
+		if t.Kind() != types.String && t.Kind() != types.UntypedString {
+			return nil, false
+		}
+	case *types.Named:
+		obj := t.Obj()
+		if obj.Pkg() != e.p.types || obj.Parent() != e.p.types.Scope() || t.TypeArgs().Len() > 0 {
+			return nil, false
+		}
+		conversion = &ast.Ident{NamePos: pos, Name: e.r.identName(obj)}
+	default:
+		return nil, false
+	}
+
+	e.literals++
+	random, err := e.r.namer.Bytes(fmt.Sprintf("%s.string:%d", e.p.types.Path(), e.literals), 1)
+	if err != nil {
+		return nil, false
+	}
+	offset := int(random[0]) % len(e.key)
+	data := e.encrypt(offset, []byte(value))
+
+	elts := make([]ast.Expr, len(data))
+	for i, b := range data {
+		elts[i] = &ast.BasicLit{ValuePos: pos, Kind: token.INT, Value: fmt.Sprintf("0x%02x", b)}
+	}
+	var expr ast.Expr = &ast.CallExpr{
+		Fun:    &ast.Ident{NamePos: pos, Name: e.decoder},
+		Lparen: pos,
+		Args: []ast.Expr{
+			&ast.BasicLit{ValuePos: pos, Kind: token.INT, Value: strconv.Itoa(offset)},
+			&ast.CompositeLit{
+				Type:   &ast.ArrayType{Lbrack: pos, Elt: &ast.Ident{NamePos: pos, Name: "byte"}},
+				Lbrace: pos,
+				Elts:   elts,
+				Rbrace: pos,
+			},
+		},
+		Rparen: pos,
+	}
+	if conversion != nil {
+		expr = &ast.CallExpr{Fun: conversion, Lparen: pos, Args: []ast.Expr{expr}, Rparen: pos}
+	}
+
+	e.used = true
+	return expr, true
+}
+
+func (e *stringEncrypter) encrypt(offset int, data []byte) []byte {
+	out := make([]byte, len(data))
+	for i, b := range data {
+		out[i] = b ^ e.key[(offset+i)%len(e.key)]
+	}
+	return out
+}
+
+// writeDecoder generates the file holding the package's key and decoder
+func (e *stringEncrypter) writeDecoder(job *packageJob) error {
+	keyName, err := e.r.aliasIdent(e.p.types.Path()+".key:string", false)
+	if err != nil {
+		return err
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "package %s\n\n", e.p.types.Name())
+	fmt.Fprintf(&b, "var %s = [...]byte{", keyName)
+	for i, k := range e.key {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "0x%02x", k)
+	}
+	b.WriteString("}\n\n")
+	fmt.Fprintf(&b, "func %s(offset int, data []byte) string {\n", e.decoder)
+	b.WriteString("\tfor i := range data {\n")
+	fmt.Fprintf(&b, "\t\tdata[i] ^= %s[(offset+i)%%len(%s)]\n", keyName, keyName)
+	b.WriteString("\t}\n\treturn string(data)\n}\n")
+
+	code, err := format.Source(b.Bytes())
+	if err != nil {
+		return err
+	}
+
+	key := path.Join(e.p.types.Path(), "strings:decoder")
+	name, err := e.r.namer.Alias(key)
+	if err != nil {
+		return err
+	}
+	e.r.mapping.Files[key] = name + ".go"
+	job.generated = append(job.generated, &generatedFile{name: name + ".go", data: code})
+	return nil
+}
+
+// walkExprs calls fn on each expression held directly by n and stores the
+// expression it returns in its place
+func walkExprs(n ast.Node, fn func(ast.Expr) ast.Expr) {
+	exprs := func(list []ast.Expr) {
+		for i := range list {
+			list[i] = fn(list[i])
+		}
+	}
+
+	switch n := n.(type) {
+	case *ast.CallExpr:
+		n.Fun = fn(n.Fun)
+		exprs(n.Args)
+	case *ast.CompositeLit:
+		exprs(n.Elts)
+	case *ast.KeyValueExpr:
+		n.Key = fn(n.Key)
+		n.Value = fn(n.Value)
+	case *ast.BinaryExpr:
+		n.X = fn(n.X)
+		n.Y = fn(n.Y)
+	case *ast.UnaryExpr:
+		n.X = fn(n.X)
+	case *ast.ParenExpr:
+		n.X = fn(n.X)
+	case *ast.IndexExpr:
+		n.X = fn(n.X)
+		n.Index = fn(n.Index)
+	case *ast.SliceExpr:
+		n.X = fn(n.X)
+	case *ast.SelectorExpr:
+		n.X = fn(n.X)
+	case *ast.StarExpr:
+		n.X = fn(n.X)
+	case *ast.TypeAssertExpr:
+		n.X = fn(n.X)
+	case *ast.AssignStmt:
+		exprs(n.Rhs)
+	case *ast.ReturnStmt:
+		exprs(n.Results)
+	case *ast.ExprStmt:
+		n.X = fn(n.X)
+	case *ast.SendStmt:
+		n.Value = fn(n.Value)
+	case *ast.CaseClause:
+		exprs(n.List)
+	case *ast.SwitchStmt:
+		if n.Tag != nil {
+			n.Tag = fn(n.Tag)
+		}
+	case *ast.IfStmt:
+		n.Cond = fn(n.Cond)
+	case *ast.ForStmt:
+		if n.Cond != nil {
+			n.Cond = fn(n.Cond)
+		}
+	case *ast.RangeStmt:
+		n.X = fn(n.X)
+	case *ast.ValueSpec:
+		exprs(n.Values)
+	}
+}
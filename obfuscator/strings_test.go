@@ -0,0 +1,183 @@
+package obfuscator
+
+import (
+	"bytes"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStringEncrypt(t *testing.T) {
+	e := &stringEncrypter{key: []byte{0x13, 0x37, 0xc0, 0xde}}
+	tests := []struct {
+		offset int
+		value  string
+	}{
+		{0, "a"},
+		{3, "secret"},
+		{2, "longer than the key by far"},
+	}
+	for _, test := range tests {
+		data := e.encrypt(test.offset, []byte(test.value))
+		if bytes.Equal(data, []byte(test.value)) {
+			t.Errorf("%q isn't encrypted", test.value)
+		}
+		if got := string(e.encrypt(test.offset, data)); got != test.value {
+			t.Errorf("%q decrypted to %q", test.value, got)
+		}
+	}
+}
+
+func TestWalkExprs(t *testing.T) {
+	const src = `package p
+
+var x = f("a", []string{"b"}, map[string]int{"c": 1}, "d"+"e", ("f"), s["g"], *p, "h"[1:])
+`
+	const want = `package p
+
+var x = f(S, []string{S}, map[string]int{S: 1}, S+S, (S), s[S], *p, S[1:])
+`
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "p.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ast.Inspect(file, func(n ast.Node) bool {
+		walkExprs(n, func(expr ast.Expr) ast.Expr {
+			if lit, ok := expr.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+				return ast.NewIdent("S")
+			}
+			return expr
+		})
+		return true
+	})
+	var b bytes.Buffer
+	if err := format.Node(&b, fset, file); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestRewriteEncryptsStrings(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"go.mod": "module example.com/strs\n\ngo 1.16\n",
+		"cmd/app/main.go": `package main
+
+import (
+	"fmt"
+
+	"example.com/strs/secrets"
+)
+
+func main() { fmt.Println(secrets.Report()) }
+`,
+		"secrets/secrets.go": `package secrets
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+type color string
+
+// red documents a converted declaration
+const red color = "crimson-red"
+
+const (
+	// greeting documents a converted spec
+	greeting = "hello-secret"
+	farewell = "bye-secret" // farewell has a trailing remark
+	Exported = "exported-visible"
+	sized    = "four"
+)
+
+const (
+	counted = "iota-secret"
+	second  = iota
+)
+
+var buffer [len(sized)]byte
+
+type record struct {
+	Name string ` + "`json:\"record-name\"`" + `
+}
+
+func describe(kind string) string {
+	switch kind {
+	case "switch-case-a":
+		return "matched-a"
+	case "switch-case-b":
+		return "matched-b"
+	}
+	return "unmatched"
+}
+
+// Report uses strings in every context
+func Report() string {
+	const local = "local-const-secret"
+	m := map[string]string{"map-key": "map-value"}
+	data, _ := json.Marshal(record{Name: "json-name"})
+	return fmt.Sprint(greeting, farewell, " ", Exported, " ", len(buffer), " ", red, " ",
+		describe("switch-case-b"), " ", m["map-key"], " ", local, " ", string(data), " ", counted, second)
+}
+`,
+	})
+
+	target := t.TempDir()
+	out := runTarget(t, Options{
+		SrcPath:        filepath.Join(root, "cmd", "app"),
+		RootPath:       root,
+		TargetPath:     target,
+		EncryptStrings: true,
+	})
+	want := `hello-secretbye-secret exported-visible 4 crimson-red matched-b map-value local-const-secret {"record-name":"json-name"} iota-secret1` + "\n"
+	if out != want {
+		t.Errorf("target printed %q, want %q", out, want)
+	}
+
+	var sources strings.Builder
+	for _, name := range targetFiles(t, target) {
+		if strings.HasSuffix(name, ".go") {
+			data, err := ioutil.ReadFile(filepath.Join(target, filepath.FromSlash(name)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			sources.Write(data)
+		}
+	}
+	tests := []struct {
+		text string
+		kept bool
+	}{
+		{`"hello-secret"`, false},
+		{`"bye-secret"`, false},
+		{`"crimson-red"`, false},
+		{`"switch-case-a"`, false},
+		{`"matched-b"`, false},
+		{`"map-key"`, false},
+		{`"local-const-secret"`, false},
+		{`"json-name"`, false},
+		{`"exported-visible"`, true},
+		{`"four"`, true},
+		{`"iota-secret"`, true},
+		{`json:"record-name"`, true},
+		{`"encoding/json"`, true},
+		// comments stay with the constants converted to variables
+		{"// red documents a converted declaration\nvar red = ", true},
+		{"// greeting documents a converted spec\n\tgreeting = ", true},
+		{"}) // farewell has a trailing remark\n)\n\nconst (\n\tExported", true},
+	}
+	for _, test := range tests {
+		if kept := strings.Contains(sources.String(), test.text); kept != test.kept {
+			t.Errorf("%s kept = %v, want %v", test.text, kept, test.kept)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package obfuscator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// errors
+var (
+	ErrTagPolicy = errors.New("tag policy must be keep, strip or encrypt")
+)
+
+// TagPolicy is what's done to the struct tags of internal types
+type TagPolicy int
+
+// tag policies
+const (
+	// KeepTags leaves tags as they are
+	KeepTags TagPolicy = iota
+	// StripTags removes tags
+	StripTags
+	// EncryptTags replaces the names given by encoding tags with aliases,
+	// the same name gets the same alias in every type. Other tags are kept
+	EncryptTags
+)
+
+var tagPolicyNames = []string{"keep", "strip", "encrypt"}
+
+func (p TagPolicy) String() string {
+	if int(p) < len(tagPolicyNames) {
+		return tagPolicyNames[p]
+	}
+	return fmt.Sprintf("TagPolicy(%d)", int(p))
+}
+
+// ParseTagPolicy parses the name of a tag policy
+func ParseTagPolicy(name string) (TagPolicy, error) {
+	for i, policyName := range tagPolicyNames {
+		if name == policyName {
+			return TagPolicy(i), nil
+		}
+	}
+	return KeepTags, ErrTagPolicy
+}
+
+const (
+	// internalDirective marks a type whose values never leave the process
+	internalDirective = "//gobf:internal"
+)
+
+// nameTagKeys are tag keys whose value starts with the name a field is
+// encoded under, followed by comma separated options
+var nameTagKeys = map[string]struct{}{
+	"json": {}, "xml": {}, "yaml": {}, "toml": {}, "bson": {}, "msgpack": {},
+	"mapstructure": {}, "form": {}, "query": {}, "csv": {}, "db": {},
+}
+
+// TagChange is a struct tag rewritten by the tag policy
+type TagChange struct {
+	Type   string `json:"type"`
+	Field  string `json:"field"`
+	Before string `json:"before"`
+	After  string `json:"after,omitempty"`
+}
+
+// eachInternalType calls fn with every type declared in file that's marked
+// internal by a //gobf:internal comment on it or its declaration, or by
+// InternalTypes rules matching the import path and name joined by a dot
+func (r *rewriter) eachInternalType(importPath string, file *ast.File, fn func(spec *ast.TypeSpec)) {
+	ast.Inspect(file, func(n ast.Node) bool {
+		decl, ok := n.(*ast.GenDecl)
+		if !ok || decl.Tok != token.TYPE {
+			return true
+		}
+		for _, spec := range decl.Specs {
+			spec := spec.(*ast.TypeSpec)
+			name := spec.Name.Name
+			if hasDirective(decl.Doc, internalDirective) || hasDirective(spec.Doc, internalDirective) ||
+				r.policy.internalTypes.match(importPath+"."+name) ||
+				r.policy.internalTypes.match(unvendoredPath(importPath)+"."+name) {
+				fn(spec)
+			}
+		}
+		return true
+	})
+}
+
+// hasDirective reports whether doc has a line holding the directive
+func hasDirective(doc *ast.CommentGroup, directive string) bool {
+	if doc == nil {
+		return false
+	}
+	for _, c := range doc.List {
+		if c.Text == directive || strings.HasPrefix(c.Text, directive+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+// collectInternalTypes records the internal types of p by the position of
+// their names
+func (r *rewriter) collectInternalTypes(p *loadedPackage) {
+	for _, file := range p.files {
+		r.eachInternalType(p.build.ImportPath, file, func(spec *ast.TypeSpec) {
+			r.internalTypes[spec.Name.Pos()] = struct{}{}
+		})
+	}
+}
+
+// rewriteTags applies the tag policy to the fields of the internal types
+// declared in files, before they're renamed
+func (r *rewriter) rewriteTags(job *packageJob, files []*ast.File) error {
+	if r.options.TagPolicy == KeepTags {
+		return nil
+	}
+	pkg := job.pkg
+	var err error
+	for _, file := range files {
+		r.eachInternalType(pkg.ImportPath, file, func(spec *ast.TypeSpec) {
+			typeName := pkg.ImportPath + "." + spec.Name.Name
+			ast.Inspect(spec.Type, func(n ast.Node) bool {
+				field, ok := n.(*ast.Field)
+				if !ok || field.Tag == nil || err != nil {
+					return true
+				}
+				var change *TagChange
+				change, err = r.rewriteTag(typeName, field)
+				if change != nil {
+					r.plan.Tags = append(r.plan.Tags, change)
+				}
+				return true
+			})
+		})
+	}
+	return err
+}
+
+// rewriteTag applies the tag policy to the tag of field
+func (r *rewriter) rewriteTag(typeName string, field *ast.Field) (*TagChange, error) {
+	var names []string
+	for _, name := range field.Names {
+		names = append(names, name.Name)
+	}
+	change := &TagChange{
+		Type:   typeName,
+		Field:  strings.Join(names, ", "),
+		Before: field.Tag.Value,
+	}
+
+	if r.options.TagPolicy == StripTags {
+		field.Tag = nil
+		return change, nil
+	}
+
+	tag, err := strconv.Unquote(field.Tag.Value)
+	if err != nil {
+		return nil, err
+	}
+	pairs, ok := parseTag(tag)
+	if !ok {
+		// tags libraries can't parse either are left alone
+		return nil, nil
+	}
+	for i, pair := range pairs {
+		if _, ok := nameTagKeys[pair.key]; !ok {
+			continue
+		}
+		name, options := pair.value, ""
+		if i := strings.Index(name, ","); i != -1 {
+			name, options = name[:i], name[i:]
+		}
+		if name == "" || name == "-" {
+			continue
+		}
+		alias, err := r.namer.Alias("tag:" + name)
+		if err != nil {
+			return nil, err
+		}
+		r.mapping.Tags[name] = alias
+		pairs[i].value = alias + options
+	}
+
+	var parts []string
+	for _, pair := range pairs {
+		parts = append(parts, pair.key+":"+strconv.Quote(pair.value))
+	}
+	value := strings.Join(parts, " ")
+	if strings.Contains(value, "`") {
+		value = strconv.Quote(value)
+	} else {
+		value = "`" + value + "`"
+	}
+	if value == field.Tag.Value {
+		return nil, nil
+	}
+	field.Tag = &ast.BasicLit{ValuePos: field.Tag.ValuePos, Kind: token.STRING, Value: value}
+	change.After = value
+	return change, nil
+}
+
+// tagPair is a key and value of a struct tag
+type tagPair struct {
+	key   string
+	value string
+}
+
+// parseTag splits a tag into its key:"value" pairs the way
+// reflect.StructTag.Lookup reads them
+func parseTag(tag string) ([]tagPair, bool) {
+	var pairs []tagPair
+	for {
+		tag = strings.TrimLeft(tag, " ")
+		if tag == "" {
+			return pairs, true
+		}
+		i := 0
+		for i < len(tag) && tag[i] > ' ' && tag[i] != ':' && tag[i] != '"' && tag[i] != 0x7f {
+			i++
+		}
+		if i == 0 || i+1 >= len(tag) || tag[i] != ':' || tag[i+1] != '"' {
+			return nil, false
+		}
+		key := tag[:i]
+		tag = tag[i+1:]
+
+		i = 1
+		for i < len(tag) && tag[i] != '"' {
+			if tag[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		if i >= len(tag) {
+			return nil, false
+		}
+		value, err := strconv.Unquote(tag[:i+1])
+		if err != nil {
+			return nil, false
+		}
+		tag = tag[i+1:]
+		pairs = append(pairs, tagPair{key: key, value: value})
+	}
+}
+
+// writeTagReport writes the tag changes as indented JSON
+func writeTagReport(filename string, changes []*TagChange) error {
+	if changes == nil {
+		changes = []*TagChange{}
+	}
+	data, err := json.MarshalIndent(changes, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, append(data, '\n'), 0644)
+}
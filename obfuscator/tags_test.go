@@ -0,0 +1,179 @@
+package obfuscator
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTag(t *testing.T) {
+	tests := []struct {
+		tag   string
+		pairs []tagPair
+		ok    bool
+	}{
+		{``, nil, true},
+		{`json:"name,omitempty"`, []tagPair{{"json", "name,omitempty"}}, true},
+		{`json:"a"  db:"b"`, []tagPair{{"json", "a"}, {"db", "b"}}, true},
+		{`json:"a\"b"`, []tagPair{{"json", `a"b`}}, true},
+		{`json:name`, nil, false},
+		{`json:"unterminated`, nil, false},
+		{`:"x"`, nil, false},
+	}
+	for _, test := range tests {
+		pairs, ok := parseTag(test.tag)
+		if ok != test.ok || !reflect.DeepEqual(pairs, test.pairs) {
+			t.Errorf("parseTag(%q) = %v, %v, want %v, %v", test.tag, pairs, ok, test.pairs, test.ok)
+		}
+	}
+}
+
+func TestParseTagPolicy(t *testing.T) {
+	for _, policy := range []TagPolicy{KeepTags, StripTags, EncryptTags} {
+		got, err := ParseTagPolicy(policy.String())
+		if err != nil || got != policy {
+			t.Errorf("ParseTagPolicy(%q) = %v, %v", policy.String(), got, err)
+		}
+	}
+	if _, err := ParseTagPolicy("drop"); err != ErrTagPolicy {
+		t.Errorf("got %v, want %v", err, ErrTagPolicy)
+	}
+}
+
+const taggedSource = `package model
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// entry is only ever serialized in memory
+//gobf:internal
+type entry struct {
+	Key  string ` + "`json:\"key,omitempty\" custom:\"kept\"`" + `
+	Hits int    ` + "`json:\"hits\"`" + `
+}
+
+type record struct {
+	Key string ` + "`json:\"key\"`" + `
+}
+
+type Public struct {
+	Name string ` + "`json:\"name\"`" + `
+}
+
+// Run round trips the internal types and encodes the public one
+func Run() string {
+	in := entry{Key: "k", Hits: 2}
+	data, _ := json.Marshal(in)
+	var out entry
+	json.Unmarshal(data, &out)
+
+	rec := record{Key: "r"}
+	recData, _ := json.Marshal(rec)
+	var recOut record
+	json.Unmarshal(recData, &recOut)
+
+	public, _ := json.Marshal(Public{Name: "n"})
+	return fmt.Sprint(in == out, " ", rec == recOut, " ", string(public))
+}
+`
+
+func TestRewriteTagPolicies(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"go.mod": "module example.com/tagged\n\ngo 1.16\n",
+		"cmd/app/main.go": `package main
+
+import (
+	"fmt"
+
+	"example.com/tagged/model"
+)
+
+func main() { fmt.Println(model.Run()) }
+`,
+		"model/model.go": taggedSource,
+	})
+
+	tests := []struct {
+		policy  TagPolicy
+		kept    []string
+		dropped []string
+		changes int
+	}{
+		{KeepTags, []string{`json:"key,omitempty" custom:"kept"`, `json:"hits"`, `json:"key"`}, nil, 0},
+		{StripTags, []string{`json:"name"`}, []string{`json:"key`, `json:"hits"`, `custom:"kept"`}, 3},
+		{EncryptTags, []string{`custom:"kept"`, `,omitempty`, `json:"name"`}, []string{`json:"key`, `json:"hits"`}, 3},
+	}
+	for _, test := range tests {
+		t.Run(test.policy.String(), func(t *testing.T) {
+			state := t.TempDir()
+			target := t.TempDir()
+			options := Options{
+				SrcPath:        filepath.Join(root, "cmd", "app"),
+				RootPath:       root,
+				TargetPath:     target,
+				RenameExported: true,
+				InternalTypes:  []string{"example.com/tagged/model.record"},
+				TagPolicy:      test.policy,
+				TagReportPath:  filepath.Join(state, "tags.json"),
+				MappingPath:    filepath.Join(state, "mapping.json"),
+			}
+			if out := runTarget(t, options); out != "true true {\"name\":\"n\"}\n" {
+				t.Errorf("target printed %q", out)
+			}
+
+			var sources strings.Builder
+			for _, name := range targetFiles(t, target) {
+				data, err := ioutil.ReadFile(filepath.Join(target, filepath.FromSlash(name)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				sources.Write(data)
+			}
+			for _, text := range test.kept {
+				if !strings.Contains(sources.String(), text) {
+					t.Errorf("%s was removed", text)
+				}
+			}
+			for _, text := range test.dropped {
+				if strings.Contains(sources.String(), text) {
+					t.Errorf("%s is still in the target", text)
+				}
+			}
+
+			data, err := ioutil.ReadFile(options.TagReportPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var changes []*TagChange
+			if err := json.Unmarshal(data, &changes); err != nil {
+				t.Fatal(err)
+			}
+			if len(changes) != test.changes {
+				t.Errorf("report has %d changes, want %d", len(changes), test.changes)
+			}
+
+			mapping, err := LoadMapping(options.MappingPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.policy == EncryptTags {
+				// the same name gets the same alias in every type
+				alias := mapping.Tags["key"]
+				if alias == "" || mapping.Tags["hits"] == "" {
+					t.Fatalf("mapping tags = %v", mapping.Tags)
+				}
+				if n := strings.Count(sources.String(), `json:"`+alias); n != 2 {
+					t.Errorf("key is aliased %d times, want 2", n)
+				}
+			} else if len(mapping.Tags) != 0 {
+				t.Errorf("mapping has tags %v", mapping.Tags)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package obfuscator
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// errors
+var (
+	ErrNoTests = errors.New("no tests found under the project root")
+)
+
+// Verify rewrites the project with its tests and runs them against the
+// target tree. The go tool's output is written to stdout and stderr, a
+// failing test run is returned as an error
+func Verify(options Options, stdout, stderr io.Writer) error {
+	options.Tests = true
+	r, err := rewrite(options)
+	if err != nil {
+		return err
+	}
+	if len(r.tested) == 0 {
+		return ErrNoTests
+	}
+
+	// vet belongs to the original tree, only the tests themselves are run
+	args := append([]string{"test", "-vet=off"}, r.tested...)
+	cmd := exec.Command("go", args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if r.modules != nil {
+		cmd.Dir = options.TargetPath
+		cmd.Env = append(os.Environ(), "GO111MODULE=on")
+	} else {
+		cmd.Env = append(os.Environ(), "GOPATH="+options.TargetPath, "GO111MODULE=off")
+	}
+	return cmd.Run()
+}
@@ -0,0 +1,156 @@
+package obfuscator
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testedProject has an internal test reaching unexported names and an
+// external test importing the package by its original path
+func testedProject(t *testing.T, check string) string {
+	t.Helper()
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"go.mod": "module example.com/tested\n\ngo 1.16\n",
+		"cmd/app/main.go": `package main
+
+import (
+	"fmt"
+
+	"example.com/tested/calc"
+)
+
+func main() { fmt.Println(calc.Double(21)) }
+`,
+		"calc/calc.go": `package calc
+
+func Double(n int) int { return multiplyBy(n, factor) }
+
+const factor = 2
+
+func multiplyBy(n, m int) int { return n * m }
+`,
+		"calc/calc_test.go": `package calc
+
+import "testing"
+
+func TestScaling(t *testing.T) {
+	if got := multiplyBy(3, factor); got != ` + check + ` {
+		t.Fatalf("got %d", got)
+	}
+}
+`,
+		"calc/calc_ext_test.go": `package calc_test
+
+import (
+	"testing"
+
+	"example.com/tested/calc"
+)
+
+func TestTwice(t *testing.T) {
+	if calc.Double(4) != 8 {
+		t.Fatal("wrong result")
+	}
+}
+`,
+	})
+	return root
+}
+
+func TestVerify(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go tool not found")
+	}
+	root := testedProject(t, "6")
+	target := t.TempDir()
+	var stdout, stderr bytes.Buffer
+	err := Verify(Options{
+		SrcPath:        filepath.Join(root, "cmd", "app"),
+		RootPath:       root,
+		TargetPath:     target,
+		RenameExported: true,
+	}, &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("verify: %v\n%s%s", err, stdout.String(), stderr.String())
+	}
+
+	var tests int
+	for _, name := range targetFiles(t, target) {
+		if !strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		tests++
+		data, err := ioutil.ReadFile(filepath.Join(target, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, original := range []string{"example.com/tested", "multiplyBy", "Double", "factor"} {
+			if strings.Contains(string(data), original) {
+				t.Errorf("%s still mentions %s", name, original)
+			}
+		}
+	}
+	if tests != 2 {
+		t.Errorf("target has %d test files, want 2", tests)
+	}
+}
+
+func TestVerifyFails(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go tool not found")
+	}
+	root := testedProject(t, "7")
+	var stdout, stderr bytes.Buffer
+	err := Verify(Options{
+		SrcPath:           filepath.Join(root, "cmd", "app"),
+		RootPath:          root,
+		TargetPath:        t.TempDir(),
+		RenameIdentifiers: true,
+	}, &stdout, &stderr)
+	if err == nil {
+		t.Fatal("verify passed a failing test")
+	}
+	if !strings.Contains(stdout.String()+stderr.String(), "FAIL") {
+		t.Errorf("go test output wasn't passed through:\n%s%s", stdout.String(), stderr.String())
+	}
+}
+
+func TestVerifyNoTests(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"go.mod":          "module example.com/untested\n\ngo 1.16\n",
+		"cmd/app/main.go": "package main\n\nfunc main() {}\n",
+	})
+	err := Verify(Options{
+		SrcPath:    filepath.Join(root, "cmd", "app"),
+		RootPath:   root,
+		TargetPath: t.TempDir(),
+	}, ioutil.Discard, ioutil.Discard)
+	if err != ErrNoTests {
+		t.Errorf("verify = %v, want %v", err, ErrNoTests)
+	}
+}
+
+func TestRewriteDropsTests(t *testing.T) {
+	root := testedProject(t, "6")
+	target := t.TempDir()
+	out := runTarget(t, Options{
+		SrcPath:           filepath.Join(root, "cmd", "app"),
+		RootPath:          root,
+		TargetPath:        target,
+		RenameIdentifiers: true,
+	})
+	if out != "42\n" {
+		t.Errorf("target printed %q, want %q", out, "42\n")
+	}
+	for _, name := range targetFiles(t, target) {
+		if strings.HasSuffix(name, "_test.go") {
+			t.Errorf("test file %s was copied", name)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+
+	"github.com/slugalisk/gobf/obfuscator"
+)
+
+// scrub overwrites residual paths and build metadata in a built binary
+func scrub(args []string) error {
+	flags := flag.NewFlagSet("scrub", flag.ExitOnError)
+	outputPath := flags.String("output", "", "file to write the scrubbed binary to (defaults to scrubbing in place)")
+	mappingPath := flags.String("mapping", "", "mapping file whose original paths are scrubbed")
+	var extra stringList
+	flags.Var(&extra, "string", "additional string to scrub, may be repeated")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return usageError("scrub: expected the path of one binary")
+	}
+
+	options := obfuscator.ScrubOptions{
+		OutputPath: *outputPath,
+	}
+	var err error
+	options.Strings, err = obfuscator.DefaultScrubStrings()
+	if err != nil {
+		return err
+	}
+	options.Strings = append(options.Strings, extra...)
+	if *mappingPath != "" {
+		mapping, err := obfuscator.LoadMapping(*mappingPath)
+		if err != nil {
+			return err
+		}
+		options.Strings = append(options.Strings, mapping.OriginalNames()...)
+	}
+
+	report, err := obfuscator.Scrub(flags.Arg(0), options)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for name := range report.Strings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("%s: %d\n", name, report.Strings[name])
+	}
+	fmt.Printf("build id: %t\nbuild info: %t\n", report.BuildID, report.BuildInfo)
+	for _, name := range report.DWARFSections {
+		fmt.Printf("recompressed %s\n", name)
+	}
+	return nil
+}
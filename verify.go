@@ -0,0 +1,20 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/slugalisk/gobf/obfuscator"
+)
+
+// verify rewrites the project with its tests and runs them in the target tree
+// to show obfuscation didn't change behaviour. It takes the same flags as a
+// build
+func verify(args []string) error {
+	flag.CommandLine.Parse(args)
+	options, err := parseOptions()
+	if err != nil {
+		return err
+	}
+	return obfuscator.Verify(options, os.Stdout, os.Stderr)
+}